@@ -2,20 +2,44 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"github.com/FeelDat/urlshort/internal/app/config"
 	"github.com/FeelDat/urlshort/internal/app/handlers"
+	"github.com/FeelDat/urlshort/internal/app/policy"
 	"github.com/FeelDat/urlshort/internal/app/storage"
 	"github.com/FeelDat/urlshort/internal/custommiddleware"
+	"github.com/FeelDat/urlshort/internal/geoip"
 	log "github.com/FeelDat/urlshort/internal/logger"
+	"github.com/FeelDat/urlshort/internal/utils"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 )
 
+func newLimiter(limit int, period time.Duration) custommiddleware.Limiter {
+	if limit <= 0 {
+		return nil
+	}
+	return custommiddleware.NewMemoryLimiter(limit, period)
+}
+
+// batchEntryOverhead is the room left in each batch entry for everything but
+// its URL: the correlation ID, the alias, link options and JSON syntax.
+const batchEntryOverhead = 1024
+
+// idempotencyBodyLimit is the configured cap on bodies buffered by the
+// idempotency middleware, by default large enough for any batch the handlers
+// accept.
+func idempotencyBodyLimit(conf *config.Config) int64 {
+	if conf.IdempotencyMaxBody > 0 {
+		return conf.IdempotencyMaxBody
+	}
+	return max(int64(conf.MaxBatchSize)*int64(conf.MaxURLLength+batchEntryOverhead), 1<<20)
+}
+
 func main() {
 
 	logger, err := log.InitLogger("Info")
@@ -30,30 +54,54 @@ func main() {
 
 	rand.Seed(time.Now().UnixNano())
 
+	policyEngine, err := policy.NewEngine(conf.BlocklistPath, conf.BaseAddress, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	go policyEngine.Watch(context.Background(), conf.BlocklistReloadInterval)
+
+	ips, err := utils.NewClientIPResolver(strings.Split(conf.TrustedProxies, ","))
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	geo, err := geoip.Open(conf.GeoIPDatabase)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer geo.Close()
+
 	loggerMiddleware := custommiddleware.NewLoggerMiddleware(logger)
 	authMiddleware := custommiddleware.NewAuthMiddleware()
 	compressMIddleware := custommiddleware.NewCompressMiddleware()
+	adminMiddleware := custommiddleware.NewAdminMiddleware(conf.AdminToken)
+	apiKeys := strings.Split(conf.APIKeys, ",")
+	createLimit := custommiddleware.NewRateLimitMiddleware(newLimiter(conf.RateLimitCreate, conf.RateLimitPeriod), apiKeys, ips, logger)
+	redirectLimit := custommiddleware.NewRateLimitMiddleware(newLimiter(conf.RateLimitRedirect, conf.RateLimitPeriod), apiKeys, ips, logger)
+	unlockLimit := custommiddleware.NewRateLimitMiddleware(newLimiter(conf.RateLimitUnlock, conf.RateLimitPeriod), apiKeys, ips, logger)
+	idempotency := custommiddleware.NewIdempotencyMiddleware(custommiddleware.NewMemoryIdempotencyStore(conf.IdempotencyTTL), idempotencyBodyLimit(conf), logger)
 
 	r := chi.NewRouter()
 
 	var h handlers.Handler
-	var db *sql.DB
+	var pool *pgxpool.Pool
 
 	if conf.DatabaseAddress != "" {
-		db, err = sql.Open("pgx", conf.DatabaseAddress)
+		err = storage.InitDB(context.Background(), conf.DatabaseAddress, conf.DatabaseMigrationTimeout)
 		if err != nil {
 			logger.Fatal(err)
 		}
-		defer db.Close()
 
-		err = storage.InitDB(context.Background(), db)
+		pool, err = storage.NewPool(context.Background(), conf.DatabaseAddress, conf.DatabaseMaxConns, conf.DatabaseMinConns)
 		if err != nil {
 			logger.Fatal(err)
 		}
+		defer pool.Close()
 
-		dbRepo := storage.NewDBStorage(db)
+		dbRepo := storage.NewDBStorage(pool, conf.DatabaseQueryTimeout)
+		dbRepo = storage.NewCachedStorage(dbRepo, conf.RedirectCacheSize, conf.RedirectCacheTTL, conf.RedirectCacheNegativeTTL)
 
-		h = handlers.NewHandler(dbRepo, conf.BaseAddress, logger)
+		h = handlers.NewHandler(dbRepo, policyEngine, geo, ips, conf, logger)
 
 	} else {
 		inMemRepo, err := storage.NewInMemStorage(conf.FilePath)
@@ -61,7 +109,7 @@ func main() {
 			logger.Fatal(err)
 		}
 
-		h = handlers.NewHandler(inMemRepo, conf.BaseAddress, logger)
+		h = handlers.NewHandler(inMemRepo, policyEngine, geo, ips, conf, logger)
 	}
 
 	r.Use(middleware.Compress(5,
@@ -71,24 +119,54 @@ func main() {
 	r.Use(authMiddleware.AuthMiddleware)
 	r.Use(compressMIddleware.CompressMiddleware)
 	r.Route("/", func(r chi.Router) {
-		r.Post("/", h.ShortenURL)
+		r.With(createLimit.RateLimitMiddleware, idempotency.IdempotencyMiddleware).Post("/", h.ShortenURL)
 		r.Route("/api", func(r chi.Router) {
 			r.Route("/shorten", func(r chi.Router) {
-				r.Post("/", h.ShortenURLJSON)
-				r.Post("/batch", h.ShortenURLBatch)
+				r.Use(createLimit.RateLimitMiddleware)
+				r.With(idempotency.IdempotencyMiddleware).Post("/", h.ShortenURLJSON)
+				r.With(idempotency.IdempotencyMiddleware).Post("/batch", h.ShortenURLBatch)
+				r.Post("/stream", h.ShortenURLStream)
 			})
 			r.Route("/user", func(r chi.Router) {
 				r.Get("/urls", h.GetUsersURLS)
+				r.Get("/urls/search", h.SearchURLS)
+				r.Post("/urls/move", h.MoveURLS)
+				r.Get("/collections", h.GetCollections)
+				r.Post("/collections", h.CreateCollection)
+				r.Patch("/collections/{id}", h.RenameCollection)
+				r.Delete("/collections/{id}", h.DeleteCollection)
+				r.Delete("/collections/{id}/urls", h.DeleteCollectionURLS)
 				r.Delete("/urls", h.DeleteURLS)
+				r.Patch("/urls/{id}", h.UpdateURL)
+				r.Get("/urls/{id}/stats", h.GetClickStats)
+				r.With(createLimit.RateLimitMiddleware).Post("/urls/import", h.ImportURLS)
+				r.Get("/urls/export", h.ExportURLS)
+				r.Get("/quota", h.GetQuota)
+				r.Get("/utm", h.GetUTM)
+				r.Put("/utm", h.SetUTM)
+				r.Delete("/utm", h.DeleteUTM)
+			})
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(adminMiddleware.AdminMiddleware)
+				r.Post("/urls/block", h.BlockURLS)
+				r.Post("/urls/unblock", h.UnblockURLS)
+				r.Put("/quotas/{userID}", h.SetUserQuota)
+				r.Delete("/quotas/{userID}", h.DeleteUserQuota)
 			})
 		})
-		r.Get("/{id}", h.GetFullURL)
+		r.With(redirectLimit.RateLimitMiddleware).Get("/{id}", h.GetFullURL)
+		r.With(redirectLimit.RateLimitMiddleware).Get("/{id}/*", h.GetFullURL)
+		r.With(redirectLimit.RateLimitMiddleware).Get("/{id}+", h.PreviewURL)
+		// the static segment wins over /{id}/*, prefix links cannot pass on "qr"
+		r.With(redirectLimit.RateLimitMiddleware).Get("/{id}/qr", h.QRCode)
+		r.With(unlockLimit.LinkRateLimitMiddleware).Post("/{id}", h.UnlockURL)
+		r.With(unlockLimit.LinkRateLimitMiddleware).Post("/{id}/*", h.UnlockURL)
 		r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 			if conf.DatabaseAddress == "" {
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			if err = db.Ping(); err != nil {
+			if err := pool.Ping(r.Context()); err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
@@ -2,8 +2,11 @@ package config
 
 import (
 	"flag"
+	"fmt"
 	"github.com/caarlos0/env"
 	"log"
+	"net/http"
+	"time"
 )
 
 type Config struct {
@@ -11,6 +14,47 @@ type Config struct {
 	BaseAddress     string `env:"BASE_URL"`
 	FilePath        string `env:"FILE_STORAGE_PATH"`
 	DatabaseAddress string `env:"DATABASE_DSN"`
+
+	DatabaseMaxConns         int           `env:"DATABASE_MAX_CONNS"`
+	DatabaseMinConns         int           `env:"DATABASE_MIN_CONNS"`
+	DatabaseQueryTimeout     time.Duration `env:"DATABASE_QUERY_TIMEOUT"`
+	DatabaseMigrationTimeout time.Duration `env:"DATABASE_MIGRATION_TIMEOUT"`
+
+	AllowedURLSchemes string `env:"ALLOWED_URL_SCHEMES"`
+	MaxURLLength      int    `env:"MAX_URL_LENGTH"`
+
+	BlocklistPath           string        `env:"BLOCKLIST_PATH"`
+	BlocklistReloadInterval time.Duration `env:"BLOCKLIST_RELOAD_INTERVAL"`
+	AdminToken              string        `env:"ADMIN_TOKEN"`
+
+	GeoIPDatabase  string `env:"GEOIP_DATABASE"`
+	TrustedProxies string `env:"TRUSTED_PROXIES"`
+
+	RateLimitCreate   int           `env:"RATE_LIMIT_CREATE"`
+	RateLimitRedirect int           `env:"RATE_LIMIT_REDIRECT"`
+	RateLimitUnlock   int           `env:"RATE_LIMIT_UNLOCK"`
+	RateLimitPeriod   time.Duration `env:"RATE_LIMIT_PERIOD"`
+	APIKeys           string        `env:"API_KEYS"`
+
+	IdempotencyTTL     time.Duration `env:"IDEMPOTENCY_TTL"`
+	IdempotencyMaxBody int64         `env:"IDEMPOTENCY_MAX_BODY"`
+
+	QuotaMaxLinks     int `env:"QUOTA_MAX_LINKS"`
+	QuotaMaxBatchSize int `env:"QUOTA_MAX_BATCH_SIZE"`
+
+	MaxBatchSize    int `env:"MAX_BATCH_SIZE"`
+	StreamChunkSize int `env:"STREAM_CHUNK_SIZE"`
+
+	RedirectCode int `env:"REDIRECT_CODE"`
+
+	NotYetAvailableCode int    `env:"NOT_YET_AVAILABLE_CODE"`
+	NotYetAvailableURL  string `env:"NOT_YET_AVAILABLE_URL"`
+
+	RedirectCacheSize        int           `env:"REDIRECT_CACHE_SIZE"`
+	RedirectCacheTTL         time.Duration `env:"REDIRECT_CACHE_TTL"`
+	RedirectCacheNegativeTTL time.Duration `env:"REDIRECT_CACHE_NEGATIVE_TTL"`
+
+	QRCacheSize int `env:"QR_CACHE_SIZE"`
 }
 
 func NewConfig() (*Config, error) {
@@ -22,12 +66,55 @@ func NewConfig() (*Config, error) {
 
 	//host=localhost user=alimaldybergenov dbname=yandex sslmode=disable
 	flag.StringVar(&c.DatabaseAddress, "d", "", "database address")
+	flag.IntVar(&c.DatabaseMaxConns, "db-max-conns", 20, "max number of open database connections")
+	flag.IntVar(&c.DatabaseMinConns, "db-min-conns", 2, "number of database connections kept open when idle")
+	flag.DurationVar(&c.DatabaseQueryTimeout, "db-query-timeout", 2*time.Second, "timeout of a single database query")
+	flag.DurationVar(&c.DatabaseMigrationTimeout, "db-migration-timeout", 10*time.Minute, "timeout of the schema migrations run at startup, which build indexes over the whole urls table")
+
+	flag.StringVar(&c.AllowedURLSchemes, "url-schemes", "http,https", "comma separated schemes of URLs allowed to be shortened")
+	flag.IntVar(&c.MaxURLLength, "max-url-length", 2048, "max length of URLs allowed to be shortened")
+
+	flag.StringVar(&c.BlocklistPath, "blocklist", "", "path to the file with blocked destination domains and patterns")
+	flag.DurationVar(&c.BlocklistReloadInterval, "blocklist-reload", 30*time.Second, "how often the blocklist file is checked for changes")
+	flag.StringVar(&c.AdminToken, "admin-token", "", "bearer token of the admin API, empty disables it")
+
+	flag.StringVar(&c.GeoIPDatabase, "geoip-db", "", "path to a MaxMind-format country database, empty disables geo routing")
+	flag.StringVar(&c.TrustedProxies, "trusted-proxies", "", "comma separated CIDRs of proxies whose X-Forwarded-For header is trusted")
+
+	flag.IntVar(&c.RateLimitCreate, "rate-limit-create", 60, "requests per rate limit period a client may create links with, 0 disables the limit")
+	flag.IntVar(&c.RateLimitRedirect, "rate-limit-redirect", 600, "requests per rate limit period a client may follow links with, 0 disables the limit")
+	flag.IntVar(&c.RateLimitUnlock, "rate-limit-unlock", 10, "password attempts per rate limit period a client IP may make on each protected link, 0 disables the limit")
+	flag.DurationVar(&c.RateLimitPeriod, "rate-limit-period", time.Minute, "period of the rate limits")
+	flag.StringVar(&c.APIKeys, "api-keys", "", "comma separated API keys, rate limited per key instead of per IP")
+
+	flag.DurationVar(&c.IdempotencyTTL, "idempotency-ttl", 24*time.Hour, "how long responses are kept for replaying requests with the same Idempotency-Key")
+	flag.Int64Var(&c.IdempotencyMaxBody, "idempotency-max-body", 0, "max size in bytes of request bodies sent with an Idempotency-Key, 0 means enough for a batch of max-batch-size URLs of max-url-length")
+
+	flag.IntVar(&c.QuotaMaxLinks, "quota-max-links", 10000, "default number of links a user may own, 0 means unlimited")
+	flag.IntVar(&c.QuotaMaxBatchSize, "quota-max-batch-size", 0, "default number of URLs a user may send in one batch, 0 means max-batch-size")
+
+	flag.IntVar(&c.MaxBatchSize, "max-batch-size", 10000, "max number of URLs in a single batch request")
+	flag.IntVar(&c.StreamChunkSize, "stream-chunk-size", 500, "number of streamed URLs stored at once")
+
+	flag.IntVar(&c.RedirectCode, "redirect-code", 307, "status code of redirects of links not setting their own")
+
+	flag.IntVar(&c.NotYetAvailableCode, "not-yet-available-code", http.StatusNotFound, "status code of links requested before their activation window")
+	flag.StringVar(&c.NotYetAvailableURL, "not-yet-available-url", "", "page links requested before their activation window redirect to, instead of responding with not-yet-available-code")
+
+	flag.IntVar(&c.RedirectCacheSize, "cache-size", 10000, "max number of cached short link resolutions, 0 disables the cache")
+	flag.DurationVar(&c.RedirectCacheTTL, "cache-ttl", 5*time.Minute, "how long a resolved short link stays cached")
+	flag.DurationVar(&c.RedirectCacheNegativeTTL, "cache-negative-ttl", 30*time.Second, "how long an unknown short link stays cached")
+
+	flag.IntVar(&c.QRCacheSize, "qr-cache-size", 1000, "max number of cached QR code images, 0 disables the cache")
 	flag.Parse()
 
 	err := env.Parse(c)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if c.StreamChunkSize <= 0 {
+		return nil, fmt.Errorf("stream chunk size must be positive, got %d", c.StreamChunkSize)
+	}
 
 	return c, nil
 }
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// BlockURLS marks the short links of the JSON array in the body as blocked,
+// GetFullURL then shows a warning page instead of redirecting.
+func (h *handler) BlockURLS(w http.ResponseWriter, r *http.Request) {
+	h.setBlocked(w, r, true)
+}
+
+// UnblockURLS lifts the block of the short links of the JSON array in the body.
+func (h *handler) UnblockURLS(w http.ResponseWriter, r *http.Request) {
+	h.setBlocked(w, r, false)
+}
+
+func (h *handler) setBlocked(w http.ResponseWriter, r *http.Request, blocked bool) {
+	var shortLinks []string
+	err := json.NewDecoder(r.Body).Decode(&shortLinks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(shortLinks) == 0 {
+		http.Error(w, "empty batch", http.StatusBadRequest)
+		return
+	}
+
+	if err = h.repository.SetBlocked(r.Context(), shortLinks, blocked); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to update blocked links", "blocked", blocked, "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"github.com/FeelDat/urlshort/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxCollectionNameLength bounds the names of collections, in characters.
+const maxCollectionNameLength = 100
+
+// GetCollections lists the collections of the user by name.
+func (h *handler) GetCollections(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	collections, err := h.repository.GetCollections(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to get collections", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(collections); err != nil {
+		h.logger.Errorw("Failed to write response", "error", err)
+	}
+}
+
+func (h *handler) CreateCollection(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	name, ok := collectionName(w, r)
+	if !ok {
+		return
+	}
+
+	collection, err := h.repository.CreateCollection(r.Context(), userID, name)
+	if err != nil {
+		h.collectionError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(collection); err != nil {
+		h.logger.Errorw("Failed to write response", "error", err)
+	}
+}
+
+func (h *handler) RenameCollection(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	name, ok := collectionName(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.repository.RenameCollection(r.Context(), userID, chi.URLParam(r, "id"), name); err != nil {
+		h.collectionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteCollection removes the collection {id}, its links are kept.
+func (h *handler) DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.repository.DeleteCollection(r.Context(), userID, chi.URLParam(r, "id")); err != nil {
+		h.collectionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MoveURLS moves links of the user to a collection or, with an empty
+// collection, out of theirs.
+func (h *handler) MoveURLS(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.MoveURLSRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.URLs) == 0 {
+		http.Error(w, "empty batch", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repository.MoveURLS(r.Context(), userID, request.URLs, request.Collection); err != nil {
+		h.collectionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteCollectionURLS deletes the links in the collection {id} the way
+// DeleteURLS does, the collection itself is kept.
+func (h *handler) DeleteCollectionURLS(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	shortLinks, err := h.repository.CollectionURLS(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		h.collectionError(w, err)
+		return
+	}
+
+	if len(shortLinks) > 0 {
+		h.deleteURLS(userID, shortLinks)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// collectionName reads the trimmed name of a CollectionRequest, writing the
+// error response if it is not valid.
+func collectionName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var request models.CollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxCollectionNameLength {
+		http.Error(w, "name must be 1 to 100 characters", http.StatusUnprocessableEntity)
+		return "", false
+	}
+	return name, true
+}
+
+func (h *handler) collectionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrCollectionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, storage.ErrCollectionExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to update collections", "error", err)
+	}
+}
@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"github.com/FeelDat/urlshort/internal/app/storage"
+	"github.com/FeelDat/urlshort/internal/utils"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// redirectCodes are the status codes a link may redirect with.
+var redirectCodes = map[int]struct{}{
+	http.StatusMovedPermanently:  {},
+	http.StatusFound:             {},
+	http.StatusSeeOther:          {},
+	http.StatusTemporaryRedirect: {},
+	http.StatusPermanentRedirect: {},
+}
+
+// UpdateURL changes the options of the link {id} of the user. Options missing
+// from the body keep their values, the clearable ones set to null are
+// removed.
+func (h *handler) UpdateURL(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var opts models.LinkOptions
+	if err = json.Unmarshal(body, &opts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Clear, err = clearedOptions(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = h.validateOptions(&opts); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err = hashPassword(&opts); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	// one end of the window may be changed on its own
+	if opts.NotBefore != nil || opts.NotAfter != nil {
+		stored, err := h.repository.GetFullURL(r.Context(), chi.URLParam(r, "id"))
+		if err != nil || stored.UserID != userID {
+			if err == nil || errors.Is(err, storage.ErrLinkNotFound) || errors.Is(err, storage.ErrLinkDeleted) {
+				http.Error(w, storage.ErrLinkNotFound.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			h.logger.Errorw("Failed to load link", "error", err)
+			return
+		}
+		stored.Options.Merge(opts)
+		if err = validateWindow(stored.Options); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	link, err := h.repository.UpdateURL(r.Context(), userID, chi.URLParam(r, "id"), opts)
+	if err != nil {
+		if errors.Is(err, storage.ErrLinkNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to update link", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(models.LinkResponse{
+		ShortURL:    h.baseAddress + "/" + link.ShortURL,
+		OriginalURL: link.OriginalURL,
+		Options:     link.Options,
+	})
+	if err != nil {
+		h.logger.Errorw("Failed to write response", "error", err)
+	}
+}
+
+func validateWindow(opts models.LinkOptions) error {
+	if opts.NotBefore != nil && opts.NotAfter != nil && !opts.NotBefore.Before(*opts.NotAfter) {
+		return errors.New("not_before must be earlier than not_after")
+	}
+	return nil
+}
+
+// clearedOptions returns the clearable options body explicitly sets to null.
+// The decoded options cannot tell them from missing ones.
+func clearedOptions(body []byte) ([]string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	var cleared []string
+	for _, key := range models.ClearableOptions {
+		if raw, ok := fields[key]; ok && string(raw) == "null" {
+			cleared = append(cleared, key)
+		}
+	}
+	return cleared, nil
+}
+
+// validateOptions checks opts and normalizes the URLs of its targets.
+func (h *handler) validateOptions(opts *models.LinkOptions) error {
+	if opts.RedirectCode != nil {
+		if _, ok := redirectCodes[*opts.RedirectCode]; !ok {
+			return fmt.Errorf("redirect code %d is not supported", *opts.RedirectCode)
+		}
+	}
+	if err := validateMeta(&opts.LinkMeta); err != nil {
+		return err
+	}
+	if opts.MaxClicks != nil && *opts.MaxClicks < 1 {
+		return errors.New("max clicks must be positive")
+	}
+	if err := validateWindow(*opts); err != nil {
+		return err
+	}
+	if opts.Split != nil {
+		if err := h.validateSplit(opts.Split); err != nil {
+			return err
+		}
+	}
+	if opts.Geo != nil {
+		if err := h.validateGeo(opts.Geo); err != nil {
+			return err
+		}
+	}
+	if opts.DeviceRules != nil {
+		for i := range opts.DeviceRules.Rules {
+			if err := h.validateDeviceRule(&opts.DeviceRules.Rules[i]); err != nil {
+				return fmt.Errorf("device rule %d: %w", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Limits of the metadata of a link, in characters.
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 1000
+	maxNotesLength       = 10000
+	maxTagLength         = 50
+	maxTags              = 20
+)
+
+// validateMeta checks the lengths of meta and normalizes its tags.
+func validateMeta(meta *models.LinkMeta) error {
+	for _, field := range []struct {
+		name  string
+		value *string
+		max   int
+	}{
+		{"title", meta.Title, maxTitleLength},
+		{"description", meta.Description, maxDescriptionLength},
+		{"notes", meta.Notes, maxNotesLength},
+	} {
+		if field.value != nil && utf8.RuneCountInString(*field.value) > field.max {
+			return fmt.Errorf("%s must not be longer than %d characters", field.name, field.max)
+		}
+	}
+
+	if meta.Tags == nil {
+		return nil
+	}
+	meta.Tags = normalizeTags(meta.Tags)
+	if len(meta.Tags) > maxTags {
+		return fmt.Errorf("a link can have at most %d tags", maxTags)
+	}
+	for _, tag := range meta.Tags {
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+	}
+	return nil
+}
+
+// normalizeTags lower-cases and trims tags and drops empty and duplicate
+// ones, keeping their order.
+func normalizeTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if _, ok := seen[tag]; ok || tag == "" {
+			continue
+		}
+		seen[tag] = struct{}{}
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+var (
+	ruleOSes    = map[string]struct{}{utils.OSiOS: {}, utils.OSAndroid: {}, utils.OSWindows: {}, utils.OSMacOS: {}, utils.OSLinux: {}, utils.OSChromeOS: {}, utils.OSOther: {}}
+	ruleDevices = map[string]struct{}{utils.DeviceMobile: {}, utils.DeviceTablet: {}, utils.DeviceDesktop: {}}
+)
+
+func (h *handler) validateDeviceRule(rule *models.DeviceRule) error {
+	if _, ok := ruleOSes[rule.OS]; rule.OS != "" && !ok {
+		return fmt.Errorf("unknown os %q", rule.OS)
+	}
+	if _, ok := ruleDevices[rule.Device]; rule.Device != "" && !ok {
+		return fmt.Errorf("unknown device %q", rule.Device)
+	}
+	var err error
+	rule.URL, err = h.checkURL(rule.URL)
+	return err
+}
+
+var countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// validateGeo upper-cases the country codes and normalizes the URLs of geo.
+func (h *handler) validateGeo(geo *models.GeoTargets) error {
+	countries := make(map[string]string, len(geo.Countries))
+	for code, target := range geo.Countries {
+		code = strings.ToUpper(code)
+		if !countryCodePattern.MatchString(code) {
+			return fmt.Errorf("geo target %q: country must be an ISO 3166-1 alpha-2 code", code)
+		}
+		normalized, err := h.checkURL(target)
+		if err != nil {
+			return fmt.Errorf("geo target %s: %w", code, err)
+		}
+		countries[code] = normalized
+	}
+	geo.Countries = countries
+	return nil
+}
+
+// matchDeviceRule returns the URL of the first rule of rules matching ua.
+func matchDeviceRule(rules *models.DeviceRuleSet, ua utils.UserAgent) (string, bool) {
+	if rules == nil {
+		return "", false
+	}
+	for _, rule := range rules.Rules {
+		if rule.OS != "" && rule.OS != ua.OS {
+			continue
+		}
+		if rule.Device != "" && rule.Device != ua.Device {
+			continue
+		}
+		if rule.Bot != nil && *rule.Bot != ua.Bot {
+			continue
+		}
+		return rule.URL, true
+	}
+	return "", false
+}
+
+// checkWindow writes the response for a link requested outside of its
+// activation window and returns false in that case.
+func (h *handler) checkWindow(w http.ResponseWriter, r *http.Request, link models.URL) bool {
+	now := time.Now()
+	if link.Options.NotAfter != nil && !now.Before(*link.Options.NotAfter) {
+		w.WriteHeader(http.StatusGone)
+		return false
+	}
+	if link.Options.NotBefore != nil && now.Before(*link.Options.NotBefore) {
+		if h.notYetAvailableURL != "" {
+			http.Redirect(w, r, h.notYetAvailableURL, http.StatusFound)
+			return false
+		}
+		code := h.notYetAvailableCode
+		if code == 0 {
+			code = http.StatusNotFound
+		}
+		w.Header().Set("Retry-After", link.Options.NotBefore.UTC().Format(http.TimeFormat))
+		w.WriteHeader(code)
+		return false
+	}
+	return true
+}
+
+// splitCookieMaxAge keeps visitors on their split variant for 30 days.
+const splitCookieMaxAge = 30 * 24 * 60 * 60
+
+// pickTarget chooses where the visitor of link from country is sent to: the
+// URL of the first matching device rule, the destination of the country, a
+// split variant or the link destination. The name of the variant is empty
+// unless the link is split.
+func (h *handler) pickTarget(w http.ResponseWriter, r *http.Request, link models.URL, country string) (string, string) {
+	if ruleURL, ok := matchDeviceRule(link.Options.DeviceRules, utils.ParseUserAgent(r.UserAgent())); ok {
+		return ruleURL, ""
+	}
+	if geoURL, ok := link.Options.Geo.Target(country); ok {
+		return geoURL, ""
+	}
+	if link.Options.Split == nil || len(link.Options.Split.Targets) == 0 {
+		return link.OriginalURL, ""
+	}
+
+	// visitors stick to the variant they were sent to first
+	targets := link.Options.Split.Targets
+	cookieName := "split_" + link.ShortURL
+	if cookie, err := r.Cookie(cookieName); err == nil {
+		for _, t := range targets {
+			if t.Name == cookie.Value {
+				return t.URL, t.Name
+			}
+		}
+	}
+
+	t := pickWeighted(targets)
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    t.Name,
+		Path:     "/" + link.ShortURL,
+		MaxAge:   splitCookieMaxAge,
+		HttpOnly: true,
+	})
+	return t.URL, t.Name
+}
+
+func pickWeighted(targets []models.SplitTarget) models.SplitTarget {
+	total := 0
+	for _, t := range targets {
+		total += t.Weight
+	}
+	n := rand.Intn(total)
+	for _, t := range targets {
+		if n < t.Weight {
+			return t
+		}
+		n -= t.Weight
+	}
+	return targets[len(targets)-1]
+}
+
+func (h *handler) validateSplit(split *models.SplitSet) error {
+	names := make(map[string]struct{}, len(split.Targets))
+	for i := range split.Targets {
+		t := &split.Targets[i]
+		if t.Name == "" {
+			t.Name = strconv.Itoa(i + 1)
+		}
+		if !aliasPattern.MatchString(t.Name) {
+			return fmt.Errorf("split target %d: name must be 1 to 20 letters, digits, '_' or '-'", i+1)
+		}
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("split target %d: duplicate name %q", i+1, t.Name)
+		}
+		names[t.Name] = struct{}{}
+		if t.Weight < 1 {
+			return fmt.Errorf("split target %d: weight must be positive", i+1)
+		}
+		var err error
+		if t.URL, err = h.checkURL(t.URL); err != nil {
+			return fmt.Errorf("split target %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// destination builds the redirect target for the request r from the target
+// picked for link, it is false if r addresses a path below a link that is
+// not a prefix link or climbs out of the destination path.
+func destination(link models.URL, target string, r *http.Request) (string, bool) {
+	rest := chi.URLParam(r, "*")
+	prefix := link.Options.Prefix != nil && *link.Options.Prefix
+	passQuery := link.Options.PassQuery != nil && *link.Options.PassQuery
+
+	if rest != "" && (!prefix || !cleanPath(rest)) {
+		return "", false
+	}
+	if rest == "" && (!passQuery || r.URL.RawQuery == "") {
+		return target, true
+	}
+
+	dest, err := url.Parse(target)
+	if err != nil {
+		return target, true
+	}
+	if rest != "" {
+		dest = dest.JoinPath(rest)
+	}
+	if passQuery {
+		// parameters of the destination itself are never overridden
+		own := dest.Query()
+		extra := url.Values{}
+		for key, values := range r.URL.Query() {
+			if _, ok := own[key]; !ok {
+				extra[key] = values
+			}
+		}
+		if len(extra) > 0 {
+			if dest.RawQuery != "" {
+				dest.RawQuery += "&"
+			}
+			dest.RawQuery += extra.Encode()
+		}
+	}
+	return dest.String(), true
+}
+
+// cleanPath reports whether none of the segments of the escaped path p is
+// "." or "..", which would resolve outside the path of a prefix link.
+func cleanPath(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		segment, err := url.PathUnescape(segment)
+		if err != nil || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// redirectCode is the status code link redirects with.
+func (h *handler) redirectCode(link models.URL) int {
+	if link.Options.RedirectCode != nil {
+		return *link.Options.RedirectCode
+	}
+	if h.defaultRedirectCode == 0 {
+		return http.StatusTemporaryRedirect
+	}
+	return h.defaultRedirectCode
+}
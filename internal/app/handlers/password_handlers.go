@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+)
+
+// maxPasswordLength is the longest input bcrypt accepts.
+const maxPasswordLength = 72
+
+type unlockPageData struct {
+	Error string
+}
+
+// UnlockURL checks the password submitted by the unlock form of a protected
+// link and redirects to the destination if it matches. Unprotected links have
+// nothing to unlock, they are only followed with a GET.
+func (h *handler) UnlockURL(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.lookupLink(w, r)
+	if !ok {
+		return
+	}
+	if !link.Options.Protected() {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(*link.Options.PasswordHash), []byte(r.PostFormValue("password")))
+	if err != nil {
+		h.renderPage(w, http.StatusUnauthorized, unlockPage, unlockPageData{Error: "Wrong password"})
+		return
+	}
+
+	// 303 makes the browser follow the redirect with a GET
+	h.redirect(w, r, link, http.StatusSeeOther)
+}
+
+// hashPassword replaces the password of opts by its hash, an empty password
+// removes the protection of the link.
+func hashPassword(opts *models.LinkOptions) error {
+	if opts.Password == nil {
+		return nil
+	}
+	password := *opts.Password
+	opts.Password = nil
+
+	if password == "" {
+		opts.PasswordHash = &password
+		return nil
+	}
+	if len(password) > maxPasswordLength {
+		return errors.New("password must not be longer than 72 bytes")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	encoded := string(hash)
+	opts.PasswordHash = &encoded
+	return nil
+}
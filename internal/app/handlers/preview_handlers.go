@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"github.com/FeelDat/urlshort/internal/utils"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// continueParam carries the token of the Continue link of the preview page,
+// which lets the visitor past the interstitial of the link for continueTTL.
+const (
+	continueParam = "continue"
+	continueTTL   = 10 * time.Minute
+)
+
+type previewPageData struct {
+	Title       string
+	ShortURL    string
+	Destination string
+	CreatedAt   time.Time
+	// Protected links do not disclose their destination, Continue leads to
+	// the unlock form instead of past the interstitial.
+	Protected bool
+	Continue  string
+}
+
+// PreviewURL shows where the link {id} leads to without redirecting.
+func (h *handler) PreviewURL(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.lookupLink(w, r)
+	if !ok {
+		return
+	}
+	h.renderPreview(w, r, link)
+}
+
+func (h *handler) renderPreview(w http.ResponseWriter, r *http.Request, link models.URL) {
+	// Continue repeats the request without the preview flag, unprotected
+	// links get a token to skip their interstitial
+	protected := link.Options.Protected()
+	path := "/" + link.ShortURL
+	if rest := chi.URLParam(r, "*"); rest != "" {
+		path += "/" + rest
+	}
+	query := r.URL.Query()
+	query.Del("preview")
+	query.Del(continueParam)
+	if !protected {
+		query.Set(continueParam, continueToken(link.ShortURL, time.Now().Add(continueTTL)))
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	data := previewPageData{
+		ShortURL:  h.baseAddress + "/" + link.ShortURL,
+		CreatedAt: link.CreatedAt,
+		Protected: protected,
+		Continue:  path,
+	}
+	if link.Options.Title != nil {
+		data.Title = *link.Options.Title
+	}
+	if !data.Protected {
+		data.Destination = link.OriginalURL
+	}
+
+	w.Header().Set("X-Robots-Tag", "noindex")
+	h.renderPage(w, http.StatusOK, previewPage, data)
+}
+
+// showInterstitial reports whether GetFullURL shows the preview page of link
+// instead of redirecting.
+func showInterstitial(r *http.Request, link models.URL) bool {
+	if r.URL.Query().Has("preview") {
+		return true
+	}
+	// the unlock form already stops visitors of protected links
+	if link.Options.Interstitial == nil || !*link.Options.Interstitial || link.Options.Protected() {
+		return false
+	}
+	return !validContinueToken(r.URL.Query().Get(continueParam), link.ShortURL, time.Now())
+}
+
+// continueToken signs shortLink together with the time the token expires.
+func continueToken(shortLink string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(utils.JWTSigningKey))
+	io.WriteString(mac, "continue:"+shortLink+":"+exp)
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// validContinueToken reports whether token was issued for shortLink and has
+// not expired at now.
+func validContinueToken(token string, shortLink string, now time.Time) bool {
+	exp, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(continueToken(shortLink, time.Unix(unix, 0))))
+}
+
+// withoutContinueToken removes the token of the preview page from r, so
+// that it is not passed on to the destination.
+func withoutContinueToken(r *http.Request) *http.Request {
+	query := r.URL.Query()
+	if !query.Has(continueParam) {
+		return r
+	}
+	query.Del(continueParam)
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	r = r.WithContext(r.Context())
+	r.URL = &u
+	return r
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/FeelDat/urlshort/internal/app/storage"
+	"github.com/FeelDat/urlshort/internal/utils"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Bounds of the size of QR code images, in pixels.
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 2048
+)
+
+type qrKey struct {
+	shortURL string
+	size     int
+	level    string
+	format   string
+}
+
+// QRCode serves a QR code of the short URL of the link {id}. The size,
+// level (L, M, Q or H) and format (png or svg) query parameters default to
+// 256 pixels, M and png.
+func (h *handler) QRCode(w http.ResponseWriter, r *http.Request) {
+	key, err := qrParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// codes are only drawn for links that can still be followed
+	link, err := h.repository.GetFullURL(r.Context(), key.shortURL)
+	if err != nil {
+		if errors.Is(err, storage.ErrLinkDeleted) {
+			w.WriteHeader(http.StatusGone)
+			return
+		} else if errors.Is(err, storage.ErrLinkNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		h.logger.Errorw("Failed to get full URL", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if link.Blocked || h.policy.CheckBlocklist(link.OriginalURL) != nil {
+		h.renderBlocked(w, link)
+		return
+	}
+
+	var image []byte
+	var ok bool
+	if h.qrCache != nil {
+		image, ok = h.qrCache.Get(key)
+	}
+	if !ok {
+		image, err = utils.EncodeQR(h.baseAddress+"/"+key.shortURL, utils.QRLevels[key.level], key.size, key.format)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			h.logger.Errorw("Failed to encode QR code", "error", err)
+			return
+		}
+		if h.qrCache != nil {
+			h.qrCache.Set(key, image, 0)
+		}
+	}
+
+	contentType := "image/png"
+	if key.format == utils.QRFormatSVG {
+		contentType = "image/svg+xml"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if _, err = w.Write(image); err != nil {
+		h.logger.Errorw("Failed to write response", "error", err)
+	}
+}
+
+func qrParams(r *http.Request) (qrKey, error) {
+	query := r.URL.Query()
+	key := qrKey{
+		shortURL: chi.URLParam(r, "id"),
+		size:     defaultQRSize,
+		level:    "M",
+		format:   utils.QRFormatPNG,
+	}
+
+	if size := query.Get("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < minQRSize || n > maxQRSize {
+			return qrKey{}, errors.New("size must be a number of pixels from 64 to 2048")
+		}
+		key.size = n
+	}
+	if level := query.Get("level"); level != "" {
+		key.level = strings.ToUpper(level)
+		if _, ok := utils.QRLevels[key.level]; !ok {
+			return qrKey{}, errors.New("level must be one of L, M, Q or H")
+		}
+	}
+	if format := query.Get("format"); format != "" {
+		key.format = strings.ToLower(format)
+		if key.format != utils.QRFormatPNG && key.format != utils.QRFormatSVG {
+			return qrKey{}, errors.New("format must be png or svg")
+		}
+	}
+	return key, nil
+}
+
+// wantQR reports whether the client asked for the QR code URLs of the links
+// it shortens with the qr query parameter.
+func wantQR(r *http.Request) bool {
+	want, _ := strconv.ParseBool(r.URL.Query().Get("qr"))
+	return want
+}
+
+// qrURL is the URL QRCode serves the code of shortURL at.
+func qrURL(shortURL string) string {
+	return shortURL + "/qr"
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+)
+
+// GetQuota reports the quota of the user along with the number of links it
+// owns.
+func (h *handler) GetQuota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	usage, err := h.quotaUsage(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to get quota usage", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(usage); err != nil {
+		h.logger.Errorw("Failed to write response", "error", err)
+	}
+}
+
+// SetUserQuota overrides the default quota of the user {userID}.
+func (h *handler) SetUserQuota(w http.ResponseWriter, r *http.Request) {
+	var quota models.Quota
+	err := json.NewDecoder(r.Body).Decode(&quota)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if quota.MaxLinks < 0 || quota.MaxBatchSize < 0 {
+		http.Error(w, "quota limits must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if err = h.repository.SetUserQuota(r.Context(), chi.URLParam(r, "userID"), &quota); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to set user quota", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUserQuota puts the user {userID} back on the default quota.
+func (h *handler) DeleteUserQuota(w http.ResponseWriter, r *http.Request) {
+	if err := h.repository.SetUserQuota(r.Context(), chi.URLParam(r, "userID"), nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to delete user quota", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// quotaUsage loads the quota in effect for userID, its batch size never
+// exceeds the server wide limit.
+func (h *handler) quotaUsage(ctx context.Context, userID string) (models.QuotaUsage, error) {
+	usage := models.QuotaUsage{Quota: h.defaultQuota}
+
+	override, err := h.repository.GetUserQuota(ctx, userID)
+	if err != nil {
+		return usage, err
+	}
+	if override != nil {
+		usage.Quota = *override
+	}
+	if h.maxBatchSize > 0 && (usage.MaxBatchSize == 0 || usage.MaxBatchSize > h.maxBatchSize) {
+		usage.MaxBatchSize = h.maxBatchSize
+	}
+
+	usage.Links, err = h.repository.CountUsersURLS(ctx, userID)
+	return usage, err
+}
+
+// remainingLinks is the number of links that may still be created, -1 if
+// unlimited.
+func remainingLinks(usage models.QuotaUsage) int {
+	if usage.MaxLinks == 0 {
+		return -1
+	}
+	if usage.Links >= usage.MaxLinks {
+		return 0
+	}
+	return usage.MaxLinks - usage.Links
+}
+
+// enforceQuota writes an error response and returns false if creating n
+// links would exceed the quota of userID.
+func (h *handler) enforceQuota(w http.ResponseWriter, ctx context.Context, userID string, n int) (models.QuotaUsage, bool) {
+	usage, err := h.quotaUsage(ctx, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to get quota usage", "error", err)
+		return usage, false
+	}
+
+	if usage.MaxBatchSize > 0 && n > usage.MaxBatchSize {
+		http.Error(w, fmt.Sprintf("batch of %d URLs exceeds the limit of %d", n, usage.MaxBatchSize), http.StatusRequestEntityTooLarge)
+		return usage, false
+	}
+	if remaining := remainingLinks(usage); remaining >= 0 && n > remaining {
+		http.Error(w, fmt.Sprintf("link quota exceeded: %d of %d links used, %d more requested", usage.Links, usage.MaxLinks, n), http.StatusForbidden)
+		return usage, false
+	}
+	return usage, true
+}
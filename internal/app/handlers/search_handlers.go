@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/FeelDat/urlshort/internal/utils"
+	"net/http"
+	"strconv"
+)
+
+// Page sizes of SearchURLS.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchURLS searches the links of the user for the words of the q query
+// parameter, paginated by limit and offset.
+func (h *handler) SearchURLS(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := query.Get("q")
+	if len(utils.SearchTerms(q)) == 0 {
+		http.Error(w, "q must contain at least one word", http.StatusBadRequest)
+		return
+	}
+	limit, offset, err := pageParams(query.Get("limit"), query.Get("offset"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	result, err := h.repository.SearchURLS(r.Context(), userID, h.baseAddress, q, limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to search URLs", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(result); err != nil {
+		h.logger.Errorw("Failed to write response", "error", err)
+	}
+}
+
+func pageParams(limitParam, offsetParam string) (int, int, error) {
+	limit, offset := defaultSearchLimit, 0
+	if limitParam != "" {
+		n, err := strconv.Atoi(limitParam)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return 0, 0, errors.New("limit must be a number from 1 to 100")
+		}
+		limit = n
+	}
+	if offsetParam != "" {
+		n, err := strconv.Atoi(offsetParam)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("offset must not be negative")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"github.com/FeelDat/urlshort/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"time"
+)
+
+// GetClickStats reports the clicks of the link {id} of the user.
+func (h *handler) GetClickStats(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := h.repository.GetClickStats(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, storage.ErrLinkNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to get click stats", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(stats); err != nil {
+		h.logger.Errorw("Failed to write response", "error", err)
+	}
+}
+
+const (
+	// clickQueueSize bounds the clicks waiting to be stored, further clicks
+	// are dropped rather than holding up redirects.
+	clickQueueSize = 4096
+	// clickBatchSize is the most clicks stored at once.
+	clickBatchSize = 256
+)
+
+// recordClick queues click for writeClicks without holding up the redirect.
+func (h *handler) recordClick(click models.Click) {
+	select {
+	case h.clicks <- click:
+	default:
+		h.droppedClicks.Add(1)
+	}
+}
+
+// writeClicks stores the queued clicks in batches of whatever has piled up
+// while the previous batch was written.
+func (h *handler) writeClicks() {
+	batch := make([]models.Click, 0, clickBatchSize)
+	for click := range h.clicks {
+		batch = append(batch[:0], click)
+	fill:
+		for len(batch) < clickBatchSize {
+			select {
+			case click := <-h.clicks:
+				batch = append(batch, click)
+			default:
+				break fill
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := h.repository.RecordClicks(ctx, batch); err != nil {
+			h.logger.Errorw("Failed to record clicks", "count", len(batch), "error", err)
+		}
+		cancel()
+		if n := h.droppedClicks.Swap(0); n > 0 {
+			h.logger.Warnw("Dropped clicks, the click queue was full", "count", n)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+)
+
+var blockedPage = template.Must(template.New("blocked").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Link blocked</title></head>
+<body>
+<h1>This link has been blocked</h1>
+<p>The short link <code>{{.ShortURL}}</code> points to a destination that was reported as harmful, so we do not redirect to it.</p>
+</body>
+</html>
+`))
+
+var unlockPage = template.Must(template.New("unlock").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Protected link</title></head>
+<body>
+<h1>This link is password protected</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post">
+<label>Password <input type="password" name="password" autofocus required></label>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{with .Title}}{{.}}{{else}}Link preview{{end}}</title></head>
+<body>
+<h1>{{with .Title}}{{.}}{{else}}Link preview{{end}}</h1>
+<p>The short link <code>{{.ShortURL}}</code> leads to
+{{if .Protected}}a password protected destination.{{else}}<code>{{.Destination}}</code>{{end}}</p>
+{{if not .CreatedAt.IsZero}}<p>Created on {{.CreatedAt.UTC.Format "January 2, 2006"}}</p>{{end}}
+<p><a href="{{.Continue}}">Continue{{if not .Protected}} to the destination{{end}}</a></p>
+</body>
+</html>
+`))
+
+// renderPage executes page into a buffer first, so that a template error
+// still results in a clean 500 response.
+func (h *handler) renderPage(w http.ResponseWriter, status int, page *template.Template, data any) {
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logger.Errorw("Failed to render page", "page", page.Name(), "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		h.logger.Errorw("Failed to write response", "error", err)
+	}
+}
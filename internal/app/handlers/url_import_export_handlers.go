@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// csvColumns holds the positions of the import columns, -1 if absent.
+type csvColumns struct {
+	url         int
+	alias       int
+	correlation int
+	title       int
+	// tags holds every tag column, a cell may list several tags.
+	tags []int
+}
+
+// Without a header row the columns are expected in this order.
+var defaultCSVColumns = csvColumns{url: 0, alias: 1, correlation: 2, title: -1}
+
+var exportCSVHeader = []string{"short_url", "original_url", "title", "tags", "collection"}
+
+// csvTagSeparator separates the tags of a CSV cell.
+const csvTagSeparator = ";"
+
+// ImportURLS shortens the URLs of a CSV file with the columns original_url,
+// alias and correlation_id, the last two being optional. A header row naming
+// the columns may be given to reorder them and to add title and tag columns,
+// the tags of a cell being separated by semicolons. Entries without a
+// correlation ID are identified by their line number in the results.
+func (h *handler) ImportURLS(w http.ResponseWriter, r *http.Request) {
+
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	urls, err := readImportCSV(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.logger.Errorw("Failed to read URLs from csv", "error", err)
+		return
+	}
+
+	if len(urls) == 0 {
+		http.Error(w, "empty batch", http.StatusBadRequest)
+		return
+	}
+
+	cntx := context.WithValue(r.Context(), models.CtxKey("userID"), userID)
+
+	usage, ok := h.enforceQuota(w, cntx, userID, len(urls))
+	if !ok {
+		return
+	}
+
+	result, err := h.shortenBatch(cntx, urls, usage.MaxLinks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to store imported URLs", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(batchStatusCode(result))
+	if err = json.NewEncoder(w).Encode(result); err != nil {
+		h.logger.Errorw("Failed to write response", "error", err)
+	}
+}
+
+func readImportCSV(body io.Reader) ([]models.URLBatchRequest, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	columns := defaultCSVColumns
+	var urls []models.URLBatchRequest
+
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return urls, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if first {
+			if header, ok := parseCSVHeader(record); ok {
+				columns = header
+				continue
+			}
+		}
+
+		req := models.URLBatchRequest{
+			OriginalURL:   csvField(record, columns.url),
+			Alias:         csvField(record, columns.alias),
+			CorrelationID: csvField(record, columns.correlation),
+		}
+		if title := csvField(record, columns.title); title != "" {
+			req.Title = &title
+		}
+		for _, i := range columns.tags {
+			if cell := csvField(record, i); cell != "" {
+				req.Tags = append(req.Tags, strings.Split(cell, csvTagSeparator)...)
+			}
+		}
+		if req.CorrelationID == "" {
+			line, _ := reader.FieldPos(0)
+			req.CorrelationID = strconv.Itoa(line)
+		}
+		urls = append(urls, req)
+	}
+}
+
+func parseCSVHeader(record []string) (csvColumns, bool) {
+	columns := csvColumns{url: -1, alias: -1, correlation: -1, title: -1}
+	for i, name := range record {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "original_url", "url":
+			columns.url = i
+		case "alias":
+			columns.alias = i
+		case "correlation_id":
+			columns.correlation = i
+		case "title":
+			columns.title = i
+		case "tag", "tags":
+			columns.tags = append(columns.tags, i)
+		}
+	}
+	return columns, columns.url != -1
+}
+
+func csvField(record []string, i int) string {
+	if i < 0 || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// ExportURLS writes all links of the user as CSV (the default) or as a JSON
+// array, encoding them one by one instead of building the whole document.
+func (h *handler) ExportURLS(w http.ResponseWriter, r *http.Request) {
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, "format must be csv or json", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	urls, err := h.repository.GetUsersURLS(r.Context(), userID, h.baseAddress, models.URLFilter{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="urls.`+format+`"`)
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write(exportCSVHeader)
+		for _, u := range urls {
+			cw.Write(exportCSVRecord(u))
+		}
+		cw.Flush()
+		if err = cw.Error(); err != nil {
+			h.logger.Errorw("Failed to write csv export", "error", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	io.WriteString(w, "[")
+	for i, u := range urls {
+		if i > 0 {
+			io.WriteString(w, ",")
+		}
+		if err = enc.Encode(u); err != nil {
+			h.logger.Errorw("Failed to write json export", "error", err)
+			return
+		}
+	}
+	io.WriteString(w, "]")
+}
+
+func exportCSVRecord(u models.UsersURLS) []string {
+	var title string
+	if u.Title != nil {
+		title = *u.Title
+	}
+	return []string{u.ShortURL, u.OriginalURL, title, strings.Join(u.Tags, csvTagSeparator), u.Collection}
+}
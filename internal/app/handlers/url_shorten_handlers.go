@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"github.com/FeelDat/urlshort/internal/app/config"
 	"github.com/FeelDat/urlshort/internal/app/models"
+	"github.com/FeelDat/urlshort/internal/app/policy"
 	"github.com/FeelDat/urlshort/internal/app/storage"
+	"github.com/FeelDat/urlshort/internal/geoip"
+	"github.com/FeelDat/urlshort/internal/lrucache"
 	"github.com/FeelDat/urlshort/internal/utils"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgerrcode"
@@ -13,58 +18,128 @@ import (
 	"go.uber.org/zap"
 	"io"
 	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type Handler interface {
 	GetFullURL(w http.ResponseWriter, r *http.Request)
+	PreviewURL(w http.ResponseWriter, r *http.Request)
+	QRCode(w http.ResponseWriter, r *http.Request)
 	ShortenURL(w http.ResponseWriter, r *http.Request)
 	ShortenURLJSON(w http.ResponseWriter, r *http.Request)
 	ShortenURLBatch(w http.ResponseWriter, r *http.Request)
+	ShortenURLStream(w http.ResponseWriter, r *http.Request)
 	GetUsersURLS(w http.ResponseWriter, r *http.Request)
+	SearchURLS(w http.ResponseWriter, r *http.Request)
+	MoveURLS(w http.ResponseWriter, r *http.Request)
+	GetCollections(w http.ResponseWriter, r *http.Request)
+	CreateCollection(w http.ResponseWriter, r *http.Request)
+	RenameCollection(w http.ResponseWriter, r *http.Request)
+	DeleteCollection(w http.ResponseWriter, r *http.Request)
+	DeleteCollectionURLS(w http.ResponseWriter, r *http.Request)
 	DeleteURLS(w http.ResponseWriter, r *http.Request)
+	ImportURLS(w http.ResponseWriter, r *http.Request)
+	ExportURLS(w http.ResponseWriter, r *http.Request)
+	BlockURLS(w http.ResponseWriter, r *http.Request)
+	UnblockURLS(w http.ResponseWriter, r *http.Request)
+	GetQuota(w http.ResponseWriter, r *http.Request)
+	SetUserQuota(w http.ResponseWriter, r *http.Request)
+	DeleteUserQuota(w http.ResponseWriter, r *http.Request)
+	UpdateURL(w http.ResponseWriter, r *http.Request)
+	UnlockURL(w http.ResponseWriter, r *http.Request)
+	GetClickStats(w http.ResponseWriter, r *http.Request)
+	GetUTM(w http.ResponseWriter, r *http.Request)
+	SetUTM(w http.ResponseWriter, r *http.Request)
+	DeleteUTM(w http.ResponseWriter, r *http.Request)
 }
 
 type handler struct {
-	repository  storage.Repository
-	baseAddress string
-	logger      *zap.SugaredLogger
+	repository          storage.Repository
+	normalizer          *utils.URLNormalizer
+	policy              *policy.Engine
+	geo                 *geoip.DB
+	ips                 *utils.ClientIPResolver
+	qrCache             *lrucache.Cache[qrKey, []byte]
+	baseAddress         string
+	maxBatchSize        int
+	streamChunkSize     int
+	defaultQuota        models.Quota
+	defaultRedirectCode int
+	notYetAvailableCode int
+	notYetAvailableURL  string
+	clicks              chan models.Click
+	droppedClicks       atomic.Int64
+	logger              *zap.SugaredLogger
 }
 
-func NewHandler(repo storage.Repository, baseAddress string, logger *zap.SugaredLogger) Handler {
-	return &handler{
-		repository:  repo,
-		baseAddress: baseAddress,
-		logger:      logger,
+func NewHandler(repo storage.Repository, policyEngine *policy.Engine, geo *geoip.DB, ips *utils.ClientIPResolver, conf *config.Config, logger *zap.SugaredLogger) Handler {
+	var qrCache *lrucache.Cache[qrKey, []byte]
+	if conf.QRCacheSize > 0 {
+		qrCache = lrucache.New[qrKey, []byte](conf.QRCacheSize)
 	}
+	// the base address is normalized once, handlers only read it
+	baseAddress, err := utils.AddPrefix(conf.BaseAddress)
+	if err != nil {
+		logger.Errorw("Failed to add prefix to baseAddress", "error", err)
+		baseAddress = conf.BaseAddress
+	}
+	h := &handler{
+		repository:          repo,
+		normalizer:          utils.NewURLNormalizer(strings.Split(conf.AllowedURLSchemes, ","), conf.MaxURLLength),
+		policy:              policyEngine,
+		geo:                 geo,
+		ips:                 ips,
+		qrCache:             qrCache,
+		baseAddress:         baseAddress,
+		maxBatchSize:        conf.MaxBatchSize,
+		streamChunkSize:     conf.StreamChunkSize,
+		defaultQuota:        models.Quota{MaxLinks: conf.QuotaMaxLinks, MaxBatchSize: conf.QuotaMaxBatchSize},
+		defaultRedirectCode: conf.RedirectCode,
+		notYetAvailableCode: conf.NotYetAvailableCode,
+		notYetAvailableURL:  conf.NotYetAvailableURL,
+		clicks:              make(chan models.Click, clickQueueSize),
+		logger:              logger,
+	}
+	go h.writeClicks()
+	return h
 }
 
 var ctxKey models.CtxKey
 
-// for tests purposes, usually get it from env varibale
-const jwtKey = "8PNHgjK2kPunGpzMgL0ZmMdJCRKy2EnL/Cg0GbnELLI="
-
-func (h *handler) DeleteURLS(w http.ResponseWriter, r *http.Request) {
-
+// userIDFromRequest extracts the user ID from the jwt cookie set by the auth
+// middleware. On failure it writes the error response and returns false.
+func (h *handler) userIDFromRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
 	cookie, err := r.Cookie("jwt")
 	if err != nil {
 		if err == http.ErrNoCookie {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+			return "", false
 		}
 		http.Error(w, "Bad request", http.StatusBadRequest)
-		return
+		return "", false
 	}
 	jwtToken := cookie.Value
 	//jwtKey := os.Getenv("JWT_KEY")
-	userID, err := utils.GetUserIDFromToken(jwtToken, jwtKey)
+	userID, err := utils.GetUserIDFromToken(jwtToken, utils.JWTSigningKey)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	return userID, true
+}
+
+func (h *handler) DeleteURLS(w http.ResponseWriter, r *http.Request) {
+
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
 		return
 	}
 
 	var urlsToDelete []string
-	err = json.NewDecoder(r.Body).Decode(&urlsToDelete)
+	err := json.NewDecoder(r.Body).Decode(&urlsToDelete)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -75,34 +150,33 @@ func (h *handler) DeleteURLS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.deleteURLS(userID, urlsToDelete)
+
+	w.WriteHeader(http.StatusAccepted)
+
+}
+
+// deleteURLS deletes the links of userID in the background.
+func (h *handler) deleteURLS(userID string, shortLinks []string) {
 	go func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Example: 5 minutes timeout
 		defer cancel()
-		h.repository.DeleteURLS(ctx, userID, urlsToDelete, h.logger)
+		h.repository.DeleteURLS(ctx, userID, shortLinks, h.logger)
 	}()
-
-	w.WriteHeader(http.StatusAccepted)
-
 }
 
+// GetUsersURLS lists the links of the user, optionally only those tagged
+// with every tag query parameter or in the collection query parameter.
 func (h *handler) GetUsersURLS(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("jwt")
-	if err != nil {
-		if err == http.ErrNoCookie {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		http.Error(w, "Bad request", http.StatusBadRequest)
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
 		return
 	}
-	jwtToken := cookie.Value
-	//jwtKey := os.Getenv("JWT_KEY")
-	userID, err := utils.GetUserIDFromToken(jwtToken, jwtKey)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	filter := models.URLFilter{
+		Tags:       normalizeTags(r.URL.Query()["tag"]),
+		Collection: r.URL.Query().Get("collection"),
 	}
-	urls, err := h.repository.GetUsersURLS(r.Context(), userID, h.baseAddress)
+	urls, err := h.repository.GetUsersURLS(r.Context(), userID, h.baseAddress, filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -122,26 +196,108 @@ func (h *handler) GetUsersURLS(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *handler) GetFullURL(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.lookupLink(w, r)
+	if !ok {
+		return
+	}
+
+	if showInterstitial(r, link) {
+		h.renderPreview(w, r, link)
+		return
+	}
+	if link.Options.Protected() {
+		h.renderPage(w, http.StatusOK, unlockPage, unlockPageData{})
+		return
+	}
+
+	h.redirect(w, withoutContinueToken(r), link, h.redirectCode(link))
+}
+
+// lookupLink loads the link addressed by r, writing the error response if
+// it cannot be followed.
+func (h *handler) lookupLink(w http.ResponseWriter, r *http.Request) (models.URL, bool) {
 	shortURL := chi.URLParam(r, "id")
 	if shortURL == "" {
 		w.WriteHeader(http.StatusBadRequest)
-		return
+		return models.URL{}, false
 	}
-	v, err := h.repository.GetFullURL(r.Context(), shortURL)
+	link, err := h.repository.GetFullURL(r.Context(), shortURL)
 	if err != nil {
-		if err.Error() == "link is deleted" {
+		if errors.Is(err, storage.ErrLinkDeleted) {
 			h.logger.Errorw("Link is deleted", "error", err)
 			w.WriteHeader(http.StatusGone)
-			return
-		} else if err.Error() == "link does not exist" {
+			return models.URL{}, false
+		} else if errors.Is(err, storage.ErrLinkNotFound) {
 			h.logger.Errorw("Link does not exist", "error", err)
 			w.WriteHeader(http.StatusNotFound)
+			return models.URL{}, false
+		}
+		h.logger.Errorw("Failed to get full URL", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return models.URL{}, false
+	}
+
+	// the blocklist may have grown since the link was created
+	if link.Blocked || h.policy.CheckBlocklist(link.OriginalURL) != nil {
+		h.renderBlocked(w, link)
+		return models.URL{}, false
+	}
+	if !h.checkWindow(w, r, link) {
+		return models.URL{}, false
+	}
+
+	return link, true
+}
+
+// renderBlocked writes the warning page of a blocked link. The page does not
+// reveal the destination, so that it cannot be used as a link to it.
+func (h *handler) renderBlocked(w http.ResponseWriter, link models.URL) {
+	h.renderPage(w, http.StatusForbidden, blockedPage, struct{ ShortURL string }{link.ShortURL})
+}
+
+func (h *handler) redirect(w http.ResponseWriter, r *http.Request, link models.URL, code int) {
+	country := h.geo.Country(h.ips.ClientIP(r))
+	target, variant := h.pickTarget(w, r, link, country)
+	dest, ok := destination(link, target, r)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	// device, geo and split targets are only checked against the blocklist
+	// as they are picked
+	if h.policy.CheckBlocklist(dest) != nil {
+		h.renderBlocked(w, link)
+		return
+	}
+
+	if link.Options.MaxClicks != nil {
+		if err := h.repository.UseClick(r.Context(), link.ShortURL); err != nil {
+			if errors.Is(err, storage.ErrLinkExhausted) {
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+			h.logger.Errorw("Failed to count click", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 	}
 
-	w.Header().Set("Location", v)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	h.recordClick(models.Click{ShortURL: link.ShortURL, Variant: variant, Country: country, Time: time.Now()})
+
+	// only rules that may pick another target make the redirect vary
+	if rules := link.Options.DeviceRules; rules != nil && len(rules.Rules) > 0 {
+		w.Header().Add("Vary", "User-Agent")
+	}
+	if split := link.Options.Split; split != nil && len(split.Targets) > 0 {
+		w.Header().Add("Vary", "Cookie")
+	}
+	if geo := link.Options.Geo; geo != nil && len(geo.Countries) > 0 && h.geo != nil {
+		// the country is not part of the request, shared caches must not
+		// store the redirect
+		w.Header().Set("Cache-Control", "private")
+	}
+	w.Header().Set("Location", h.applyUTM(r.Context(), link, dest))
+	w.WriteHeader(code)
 }
 
 func (h *handler) ShortenURLJSON(w http.ResponseWriter, r *http.Request) {
@@ -162,36 +318,47 @@ func (h *handler) ShortenURLJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.baseAddress, err = utils.AddPrefix(h.baseAddress)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		h.logger.Errorw("Failed to add prefix to baseAddress", "error", err)
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
 		return
 	}
-	cookie, err := r.Cookie("jwt")
+	cntx := context.WithValue(r.Context(), models.CtxKey("userID"), userID)
+
+	fullURL, err := h.checkURL(request.URL)
 	if err != nil {
-		if err == http.ErrNoCookie {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		http.Error(w, err.Error(), urlErrorStatus(err))
 		return
 	}
-	jwtToken := cookie.Value
-	//jwtKey := os.Getenv("JWT_KEY")
-	userID, err := utils.GetUserIDFromToken(jwtToken, jwtKey)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err = h.validateOptions(&request.LinkOptions); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err = hashPassword(&request.LinkOptions); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	usage, ok := h.enforceQuota(w, cntx, userID, 1)
+	if !ok {
 		return
 	}
-	cntx := context.WithValue(r.Context(), models.CtxKey("userID"), userID)
 
-	shortURL, err := h.repository.ShortenURL(cntx, string(request.URL))
+	shortURL, err := h.repository.ShortenURL(cntx, fullURL, request.LinkOptions, usage.MaxLinks)
 	if err != nil {
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, storage.ErrAliasTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.UniqueViolation {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusConflict)
 			reply.Result = h.baseAddress + "/" + shortURL
+			if wantQR(r) {
+				reply.QR = qrURL(reply.Result)
+			}
 			resp, err := json.Marshal(reply)
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
@@ -214,6 +381,9 @@ func (h *handler) ShortenURLJSON(w http.ResponseWriter, r *http.Request) {
 	}
 
 	reply.Result = h.baseAddress + "/" + shortURL
+	if wantQR(r) {
+		reply.QR = qrURL(reply.Result)
+	}
 
 	resp, err := json.Marshal(reply)
 	if err != nil {
@@ -241,32 +411,32 @@ func (h *handler) ShortenURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.baseAddress, err = utils.AddPrefix(h.baseAddress)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		h.logger.Errorw("Failed to add prefix to baseAddress", "error", err)
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
 		return
 	}
-	cookie, err := r.Cookie("jwt")
+	cntx := context.WithValue(r.Context(), models.CtxKey("userID"), userID)
+
+	normalizedURL, err := h.checkURL(string(fullURL))
 	if err != nil {
-		if err == http.ErrNoCookie {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		http.Error(w, err.Error(), urlErrorStatus(err))
 		return
 	}
-	jwtToken := cookie.Value
-	//jwtKey := os.Getenv("JWT_KEY")
-	userID, err := utils.GetUserIDFromToken(jwtToken, jwtKey)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	usage, ok := h.enforceQuota(w, cntx, userID, 1)
+	if !ok {
 		return
 	}
-	cntx := context.WithValue(r.Context(), models.CtxKey("userID"), userID)
 
-	shortURL, err := h.repository.ShortenURL(cntx, string(fullURL))
+	shortURL, err := h.repository.ShortenURL(cntx, normalizedURL, models.LinkOptions{}, usage.MaxLinks)
 	if err != nil {
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, storage.ErrAliasTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.UniqueViolation {
 			w.WriteHeader(http.StatusConflict)
 			response := h.baseAddress + "/" + shortURL
@@ -312,36 +482,26 @@ func (h *handler) ShortenURLBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.baseAddress, err = utils.AddPrefix(h.baseAddress)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		h.logger.Errorw("Failed to add prefix to baseAddress", "error", err)
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
 		return
 	}
-	cookie, err := r.Cookie("jwt")
-	if err != nil {
-		if err == http.ErrNoCookie {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		http.Error(w, "Bad request", http.StatusBadRequest)
-		return
-	}
-	jwtToken := cookie.Value
-	//jwtKey := os.Getenv("JWT_KEY")
-	userID, err := utils.GetUserIDFromToken(jwtToken, jwtKey)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	cntx := context.WithValue(r.Context(), models.CtxKey("userID"), userID)
+
+	usage, ok := h.enforceQuota(w, cntx, userID, len(urls))
+	if !ok {
 		return
 	}
-	cntx := context.WithValue(r.Context(), models.CtxKey("userID"), userID)
 
-	result, err := h.repository.ShortenURLBatch(cntx, urls, h.baseAddress)
+	result, err := h.shortenBatch(cntx, urls, usage.MaxLinks)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		h.logger.Errorw("Failed to store shortened URLs batch in DB", "error", err)
 		return
 	}
+	if wantQR(r) {
+		addQRURLs(result)
+	}
 
 	resp, err := json.Marshal(result)
 	if err != nil {
@@ -351,7 +511,7 @@ func (h *handler) ShortenURLBatch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(batchStatusCode(result))
 	_, err = w.Write(resp)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -360,3 +520,111 @@ func (h *handler) ShortenURLBatch(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
+// shortenBatch stores the valid entries of urls and returns a result for
+// every entry in request order, so one bad URL does not fail the others.
+// The storage rejects the entries beyond maxLinks links of the user, 0 means
+// no limit.
+func (h *handler) shortenBatch(ctx context.Context, urls []models.URLBatchRequest, maxLinks int) ([]models.URLRBatchResponse, error) {
+	results := make([]models.URLRBatchResponse, len(urls))
+	valid := make([]models.URLBatchRequest, 0, len(urls))
+	positions := make([]int, 0, len(urls))
+
+	for i, u := range urls {
+		var err error
+		u.OriginalURL, err = h.checkURL(u.OriginalURL)
+		if err == nil && u.Alias != "" {
+			err = validateAlias(u.Alias)
+		}
+		if err == nil {
+			err = h.validateOptions(&u.LinkOptions)
+		}
+		if err == nil && u.Password != nil {
+			// hashing is deliberately slow, it is not done for whole batches
+			err = errors.New("passwords can only be set on single links")
+		}
+		if err != nil {
+			results[i] = models.URLRBatchResponse{
+				CorrelationID: u.CorrelationID,
+				Status:        models.BatchStatusInvalid,
+				Error:         err.Error(),
+			}
+			continue
+		}
+		valid = append(valid, u)
+		positions = append(positions, i)
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	stored, err := h.repository.ShortenURLBatch(ctx, valid, h.baseAddress, maxLinks)
+	if err != nil {
+		return nil, err
+	}
+	for i, res := range stored {
+		results[positions[i]] = res
+	}
+
+	return results, nil
+}
+
+// addQRURLs sets the QR code URL of every result with a short URL.
+func addQRURLs(results []models.URLRBatchResponse) {
+	for i := range results {
+		if results[i].ShortURL != "" {
+			results[i].QR = qrURL(results[i].ShortURL)
+		}
+	}
+}
+
+// batchStatusCode is 201 if at least one link was created, 200 if the batch
+// only referred to existing links and 422 if every entry was rejected.
+func batchStatusCode(results []models.URLRBatchResponse) int {
+	code := http.StatusUnprocessableEntity
+	for _, res := range results {
+		switch res.Status {
+		case models.BatchStatusCreated:
+			return http.StatusCreated
+		case models.BatchStatusExisting:
+			code = http.StatusOK
+		}
+	}
+	return code
+}
+
+// checkURL normalizes rawURL and makes sure the destination is allowed by
+// the policy engine.
+func (h *handler) checkURL(rawURL string) (string, error) {
+	normalized, err := h.normalizer.Normalize(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if err = h.policy.Check(normalized); err != nil {
+		return "", err
+	}
+	return normalized, nil
+}
+
+func urlErrorStatus(err error) int {
+	if errors.Is(err, policy.ErrBlockedDestination) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnprocessableEntity
+}
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,20}$`)
+
+// reservedAliases collide with the routes served next to /{id}.
+var reservedAliases = map[string]struct{}{"api": {}, "ping": {}}
+
+func validateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) {
+		return errors.New("alias must be 1 to 20 letters, digits, '_' or '-'")
+	}
+	if _, ok := reservedAliases[alias]; ok {
+		return errors.New("alias is reserved")
+	}
+	return nil
+}
@@ -4,16 +4,28 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/FeelDat/urlshort/internal/app/config"
 	"github.com/FeelDat/urlshort/internal/app/models"
+	"github.com/FeelDat/urlshort/internal/app/policy"
 	"github.com/FeelDat/urlshort/internal/app/storage"
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"html"
+	"image/png"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -55,6 +67,82 @@ func newTestToken() (string, error) {
 	return tokenString, err
 }
 
+func newTestPolicy(t *testing.T) *policy.Engine {
+	e, err := policy.NewEngine("", "http://localhost:8080", zap.NewNop().Sugar())
+	require.NoError(t, err)
+	return e
+}
+
+// testServer serves the routes of a handler to the test user. Its client
+// does not follow redirects.
+type testServer struct {
+	*httptest.Server
+	t       *testing.T
+	storage storage.Repository
+	token   string
+}
+
+// newTestServer serves a handler built from cfg, backed by in-memory
+// storage in a temporary directory.
+func newTestServer(t *testing.T, cfg *config.Config) *testServer {
+	repo, err := storage.NewInMemStorage(filepath.Join(t.TempDir(), "short-url-db.json"))
+	require.NoError(t, err)
+	h := NewHandler(repo, newTestPolicy(t), nil, nil, cfg, zap.NewNop().Sugar())
+
+	token, err := newTestToken()
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Post("/", h.ShortenURL)
+	router.Post("/api/shorten/", h.ShortenURLJSON)
+	router.Post("/api/shorten/batch", h.ShortenURLBatch)
+	router.Get("/api/user/quota", h.GetQuota)
+	router.Get("/api/user/urls", h.GetUsersURLS)
+	router.Get("/api/user/urls/search", h.SearchURLS)
+	router.Post("/api/user/urls/move", h.MoveURLS)
+	router.Patch("/api/user/urls/{id}", h.UpdateURL)
+	router.Get("/api/user/urls/{id}/stats", h.GetClickStats)
+	router.Put("/api/user/utm", h.SetUTM)
+	router.Delete("/api/user/utm", h.DeleteUTM)
+	router.Get("/api/user/collections", h.GetCollections)
+	router.Post("/api/user/collections", h.CreateCollection)
+	router.Patch("/api/user/collections/{id}", h.RenameCollection)
+	router.Delete("/api/user/collections/{id}", h.DeleteCollection)
+	router.Delete("/api/user/collections/{id}/urls", h.DeleteCollectionURLS)
+	router.Get("/{id}", h.GetFullURL)
+	router.Get("/{id}/*", h.GetFullURL)
+	router.Get("/{id}+", h.PreviewURL)
+	router.Get("/{id}/qr", h.QRCode)
+	router.Post("/{id}", h.UnlockURL)
+
+	ts := httptest.NewServer(router)
+	t.Cleanup(ts.Close)
+	ts.Client().CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &testServer{Server: ts, t: t, storage: repo, token: token}
+}
+
+// do sends a request with the cookie of the test user.
+func (s *testServer) do(method, path, body string) *http.Response {
+	r, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	require.NoError(s.t, err)
+	r.AddCookie(&http.Cookie{Name: "jwt", Value: s.token})
+	resp, err := s.Client().Do(r)
+	require.NoError(s.t, err)
+	return resp
+}
+
+// shorten creates a link from a JSON request and returns its path.
+func (s *testServer) shorten(body string) string {
+	resp := s.do(http.MethodPost, "/api/shorten/", body)
+	defer resp.Body.Close()
+	require.Equal(s.t, http.StatusCreated, resp.StatusCode)
+	var reply models.JSONResponse
+	require.NoError(s.t, json.NewDecoder(resp.Body).Decode(&reply))
+	return strings.TrimPrefix(reply.Result, "http://localhost:8080")
+}
+
 func TestShortenURL(t *testing.T) {
 	testCases := []struct {
 		name                string
@@ -82,14 +170,12 @@ func TestShortenURL(t *testing.T) {
 		},
 	}
 
-	mockStorage, _ := storage.NewInMemStorage("short-url-db.json")
-	mockHandler := NewHandler(mockStorage, "localhost:8080", nil)
+	mockStorage, _ := storage.NewInMemStorage(filepath.Join(t.TempDir(), "short-url-db.json"))
+	mockHandler := NewHandler(mockStorage, newTestPolicy(t), nil, nil, &config.Config{BaseAddress: "localhost:8080"}, nil)
 
 	token, err := newTestToken()
 	require.NoError(t, err)
 
-	defer os.Remove("short-url-db.json")
-
 	router := chi.NewRouter()
 	router.Post("/", mockHandler.ShortenURL)
 
@@ -119,7 +205,7 @@ func TestShortenURL(t *testing.T) {
 			assert.Equal(t, tt.expectedContentType, resp.Header.Get("Content-Type"))
 
 			if tt.authenticated {
-				urls, err := mockStorage.GetUsersURLS(context.Background(), "testUserID", "localhost:8080")
+				urls, err := mockStorage.GetUsersURLS(context.Background(), "testUserID", "localhost:8080", models.URLFilter{})
 				require.NoError(t, err)
 				assert.Len(t, urls, 1)
 				assert.Equal(t, urls[0].OriginalURL, tt.longLink)
@@ -127,3 +213,740 @@ func TestShortenURL(t *testing.T) {
 		})
 	}
 }
+
+func TestShortenURLBatch(t *testing.T) {
+	mockStorage, _ := storage.NewInMemStorage(filepath.Join(t.TempDir(), "short-url-batch-db.json"))
+	mockHandler := NewHandler(mockStorage, newTestPolicy(t), nil, nil, &config.Config{BaseAddress: "localhost:8080", MaxBatchSize: 3}, zap.NewNop().Sugar())
+	token, err := newTestToken()
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Post("/api/shorten/batch", mockHandler.ShortenURLBatch)
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	testCases := []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expectedStatuses   []string
+	}{
+		{
+			name:               "partial success",
+			body:               `[{"correlation_id":"1","original_url":"https://practicum.yandex.ru/"},{"correlation_id":"2","original_url":"not a url"},{"correlation_id":"3","original_url":"https://practicum.yandex.ru/"}]`,
+			expectedStatusCode: http.StatusCreated,
+			expectedStatuses:   []string{models.BatchStatusCreated, models.BatchStatusInvalid, models.BatchStatusExisting},
+		},
+		{
+			name:               "only existing",
+			body:               `[{"correlation_id":"1","original_url":"https://practicum.yandex.ru/"}]`,
+			expectedStatusCode: http.StatusOK,
+			expectedStatuses:   []string{models.BatchStatusExisting},
+		},
+		{
+			name:               "only invalid",
+			body:               `[{"correlation_id":"1","original_url":""}]`,
+			expectedStatusCode: http.StatusUnprocessableEntity,
+			expectedStatuses:   []string{models.BatchStatusInvalid},
+		},
+		{
+			name:               "too large",
+			body:               `[{"original_url":"https://a.ru/"},{"original_url":"https://b.ru/"},{"original_url":"https://c.ru/"},{"original_url":"https://d.ru/"}]`,
+			expectedStatusCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodPost, ts.URL+"/api/shorten/batch", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			r.AddCookie(&http.Cookie{Name: "jwt", Value: token})
+
+			resp, err := ts.Client().Do(r)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			if tt.expectedStatuses == nil {
+				return
+			}
+
+			var results []models.URLRBatchResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+			require.Len(t, results, len(tt.expectedStatuses))
+			for i, res := range results {
+				assert.Equal(t, tt.expectedStatuses[i], res.Status)
+				if res.Status == models.BatchStatusInvalid {
+					assert.Empty(t, res.ShortURL)
+					assert.NotEmpty(t, res.Error)
+				} else {
+					assert.NotEmpty(t, res.ShortURL)
+				}
+			}
+			if len(results) == 3 {
+				assert.Equal(t, results[0].ShortURL, results[2].ShortURL)
+			}
+		})
+	}
+}
+
+func TestShortenURLStream(t *testing.T) {
+	mockStorage, _ := storage.NewInMemStorage(filepath.Join(t.TempDir(), "short-url-stream-db.json"))
+	mockHandler := NewHandler(mockStorage, newTestPolicy(t), nil, nil, &config.Config{BaseAddress: "localhost:8080", StreamChunkSize: 2}, zap.NewNop().Sugar())
+	token, err := newTestToken()
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Post("/api/shorten/stream", mockHandler.ShortenURLStream)
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	body := strings.Join([]string{
+		`{"correlation_id":"1","original_url":"https://practicum.yandex.ru/"}`,
+		`{"correlation_id":"2","original_url":"https://ya.ru/"}`,
+		`{not json`,
+		``,
+		`{"correlation_id":"3","original_url":"https://practicum.yandex.ru/"}`,
+		`{"correlation_id":"4","original_url":"ftp//broken"}`,
+	}, "\n")
+
+	r, err := http.NewRequest(http.MethodPost, ts.URL+"/api/shorten/stream", strings.NewReader(body))
+	require.NoError(t, err)
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	r.AddCookie(&http.Cookie{Name: "jwt", Value: token})
+
+	resp, err := ts.Client().Do(r)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	var statuses, ids []string
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var res models.URLRBatchResponse
+		require.NoError(t, dec.Decode(&res))
+		statuses = append(statuses, res.Status)
+		ids = append(ids, res.CorrelationID)
+	}
+
+	assert.Equal(t, []string{models.BatchStatusCreated, models.BatchStatusCreated, models.BatchStatusInvalid, models.BatchStatusExisting, models.BatchStatusInvalid}, statuses)
+	assert.Equal(t, []string{"1", "2", "", "3", "4"}, ids)
+}
+
+func TestImportExportURLS(t *testing.T) {
+	mockStorage, _ := storage.NewInMemStorage(filepath.Join(t.TempDir(), "short-url-import-db.json"))
+	mockHandler := NewHandler(mockStorage, newTestPolicy(t), nil, nil, &config.Config{BaseAddress: "http://localhost:8080"}, zap.NewNop().Sugar())
+	token, err := newTestToken()
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Post("/api/user/urls/import", mockHandler.ImportURLS)
+	router.Get("/api/user/urls/export", mockHandler.ExportURLS)
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	csvBody := "correlation_id,url,alias,title,tags,tag\n" +
+		"first,https://practicum.yandex.ru/,promo,Course,Ads;print,spring\n" +
+		",https://ya.ru/,,,,\n" +
+		"third,javascript,,,,\n" +
+		"fourth,https://go.dev/,promo,,,\n"
+
+	r, err := http.NewRequest(http.MethodPost, ts.URL+"/api/user/urls/import", strings.NewReader(csvBody))
+	require.NoError(t, err)
+	r.AddCookie(&http.Cookie{Name: "jwt", Value: token})
+
+	resp, err := ts.Client().Do(r)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var results []models.URLRBatchResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+	require.Len(t, results, 4)
+	assert.Equal(t, "first", results[0].CorrelationID)
+	assert.Equal(t, "http://localhost:8080/promo", results[0].ShortURL)
+	assert.Equal(t, "3", results[1].CorrelationID)
+	assert.Equal(t, models.BatchStatusCreated, results[1].Status)
+	assert.Equal(t, models.BatchStatusInvalid, results[2].Status)
+	assert.Equal(t, models.BatchStatusInvalid, results[3].Status, "alias is already taken")
+
+	r, err = http.NewRequest(http.MethodGet, ts.URL+"/api/user/urls/export?format=csv", nil)
+	require.NoError(t, err)
+	r.AddCookie(&http.Cookie{Name: "jwt", Value: token})
+
+	resp, err = ts.Client().Do(r)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "short_url,original_url,title,tags,collection", lines[0])
+	assert.Equal(t, "http://localhost:8080/promo,https://practicum.yandex.ru/,Course,ads;print;spring,", lines[1])
+}
+
+func TestQuota(t *testing.T) {
+	ts := newTestServer(t, &config.Config{BaseAddress: "localhost:8080", MaxBatchSize: 10, QuotaMaxLinks: 2})
+	do := ts.do
+
+	resp := do(http.MethodPost, "/", "https://practicum.yandex.ru/")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp = do(http.MethodPost, "/api/shorten/batch", `[{"original_url":"https://a.ru/"},{"original_url":"https://b.ru/"}]`)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = do(http.MethodPost, "/", "https://ya.ru/")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp = do(http.MethodPost, "/", "https://go.dev/")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/api/user/quota", "")
+	defer resp.Body.Close()
+	var usage models.QuotaUsage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+	assert.Equal(t, models.QuotaUsage{Quota: models.Quota{MaxLinks: 2, MaxBatchSize: 10}, Links: 2}, usage)
+
+	// concurrent requests must not get past the quota together
+	raceStorage, err := storage.NewInMemStorage(filepath.Join(t.TempDir(), "short-url-db.json"))
+	require.NoError(t, err)
+	ctx := context.WithValue(context.Background(), models.CtxKey("userID"), "testUserID")
+
+	var wg sync.WaitGroup
+	var created atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := raceStorage.ShortenURL(ctx, fmt.Sprintf("https://ya.ru/%d", i), models.LinkOptions{}, 3)
+			if err == nil {
+				created.Add(1)
+			} else {
+				assert.ErrorIs(t, err, storage.ErrQuotaExceeded)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), created.Load())
+}
+
+func TestBlockedTargets(t *testing.T) {
+	blocklist := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(blocklist, nil, 0644))
+	policyEngine, err := policy.NewEngine(blocklist, "http://localhost:8080", zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	mockStorage, _ := storage.NewInMemStorage(filepath.Join(t.TempDir(), "short-url-blocked-db.json"))
+	mockHandler := NewHandler(mockStorage, policyEngine, nil, nil, &config.Config{BaseAddress: "http://localhost:8080"}, zap.NewNop().Sugar())
+	ctx := context.WithValue(context.Background(), models.CtxKey("userID"), "testUserID")
+	shortURL, err := mockStorage.ShortenURL(ctx, "https://example.com/app", models.LinkOptions{
+		DeviceRules: &models.DeviceRuleSet{Rules: []models.DeviceRule{{OS: "ios", URL: "https://evil.com/app"}}},
+	}, 0)
+	require.NoError(t, err)
+
+	// the rule target was added to the blocklist after the link was created
+	require.NoError(t, os.WriteFile(blocklist, []byte("evil.com\n"), 0644))
+	require.NoError(t, policyEngine.Reload())
+
+	router := chi.NewRouter()
+	router.Get("/{id}", mockHandler.GetFullURL)
+
+	for ua, status := range map[string]int{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148": http.StatusForbidden,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64)":                            http.StatusTemporaryRedirect,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/"+shortURL, nil)
+		r.Header.Set("User-Agent", ua)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, status, w.Code, ua)
+		assert.NotContains(t, w.Body.String(), "evil.com", "the warning page does not reveal the destination")
+	}
+}
+
+func TestCollections(t *testing.T) {
+	ts := newTestServer(t, &config.Config{BaseAddress: "http://localhost:8080"})
+	do := ts.do
+	shorten := func(url string) string {
+		return strings.TrimPrefix(ts.shorten(`{"url":"`+url+`"}`), "/")
+	}
+	collections := func() []models.Collection {
+		resp := do(http.MethodGet, "/api/user/collections", "")
+		defer resp.Body.Close()
+		var collections []models.Collection
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&collections))
+		return collections
+	}
+
+	resp := do(http.MethodPost, "/api/user/collections", `{"name":" Posters "}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var posters models.Collection
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&posters))
+	resp.Body.Close()
+	assert.Equal(t, "Posters", posters.Name)
+
+	resp = do(http.MethodPost, "/api/user/collections", `{"name":"Posters"}`)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	first, second := shorten("https://example.com/1"), shorten("https://example.com/2")
+	resp = do(http.MethodPost, "/api/user/urls/move", `{"collection":"`+posters.ID+`","urls":["`+first+`","`+second+`"]}`)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = do(http.MethodPost, "/api/user/urls/move", `{"collection":"","urls":["`+second+`"]}`)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/api/user/urls?collection="+posters.ID, "")
+	var urls []models.UsersURLS
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&urls))
+	resp.Body.Close()
+	require.Len(t, urls, 1)
+	assert.Equal(t, "http://localhost:8080/"+first, urls[0].ShortURL)
+	assert.Equal(t, posters.ID, urls[0].Collection)
+
+	resp = do(http.MethodPatch, "/api/user/collections/"+posters.ID, `{"name":"Print"}`)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, []models.Collection{{ID: posters.ID, Name: "Print", Links: 1}}, collections())
+
+	resp = do(http.MethodDelete, "/api/user/collections/"+posters.ID+"/urls", "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	// the links are deleted in the background
+	follow := func(shortLink string) int {
+		resp := do(http.MethodGet, "/"+shortLink, "")
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Eventually(t, func() bool {
+		return follow(first) == http.StatusGone
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusTemporaryRedirect, follow(second))
+	assert.Equal(t, []models.Collection{{ID: posters.ID, Name: "Print", Links: 0}}, collections())
+
+	resp = do(http.MethodDelete, "/api/user/collections/"+posters.ID, "")
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, collections())
+
+	resp = do(http.MethodPost, "/api/user/urls/move", `{"collection":"`+posters.ID+`","urls":["`+first+`"]}`)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestLinkOptions(t *testing.T) {
+	ts := newTestServer(t, &config.Config{BaseAddress: "http://localhost:8080", RedirectCode: http.StatusFound})
+	client := ts.Client()
+	do, shorten := ts.do, ts.shorten
+
+	t.Run("redirect code", func(t *testing.T) {
+		defaultLink := shorten(`{"url":"https://ya.ru/"}`)
+		resp := do(http.MethodGet, defaultLink, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+		link := shorten(`{"url":"https://go.dev/","redirect_code":301}`)
+		resp = do(http.MethodGet, link, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+		assert.Equal(t, "https://go.dev/", resp.Header.Get("Location"))
+
+		resp = do(http.MethodPatch, "/api/user/urls"+link, `{"redirect_code":308}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = do(http.MethodGet, link, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusPermanentRedirect, resp.StatusCode)
+
+		// null puts the link back on the server default
+		resp = do(http.MethodPatch, "/api/user/urls"+link, `{"redirect_code":null}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = do(http.MethodGet, link, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+		resp = do(http.MethodPatch, "/api/user/urls"+link, `{"redirect_code":200}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+	t.Run("passthrough", func(t *testing.T) {
+		link := shorten(`{"url":"https://example.com/docs?lang=en","pass_query":true,"prefix":true}`)
+		resp := do(http.MethodGet, link+"/guide/intro?utm_source=x&lang=ru", "")
+		resp.Body.Close()
+		assert.Equal(t, "https://example.com/docs/guide/intro?lang=en&utm_source=x", resp.Header.Get("Location"))
+
+		// the extra path must not climb out of the destination path
+		for _, path := range []string{"/../admin", "/guide/./intro", "/%2e%2e/admin", "/guide/%2E%2E/%2e%2e"} {
+			resp = do(http.MethodGet, link+path, "")
+			resp.Body.Close()
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+		}
+
+		plain := shorten(`{"url":"https://example.com/plain"}`)
+		resp = do(http.MethodGet, plain+"?utm_source=x", "")
+		resp.Body.Close()
+		assert.Equal(t, "https://example.com/plain", resp.Header.Get("Location"))
+
+		resp = do(http.MethodGet, plain+"/more", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+	t.Run("utm", func(t *testing.T) {
+		resp := do(http.MethodPut, "/api/user/utm", `{"source":"newsletter","medium":"email"}`)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+		defer func() {
+			do(http.MethodDelete, "/api/user/utm", "").Body.Close()
+		}()
+
+		link := shorten(`{"url":"https://example.com/sale?utm_medium=banner","utm":{"campaign":"{id}"}}`)
+		resp = do(http.MethodGet, link, "")
+		resp.Body.Close()
+		id := strings.TrimPrefix(link, "/")
+		assert.Equal(t, "https://example.com/sale?utm_medium=banner&utm_campaign="+id+"&utm_source=newsletter", resp.Header.Get("Location"))
+	})
+	t.Run("password", func(t *testing.T) {
+		link := shorten(`{"url":"https://example.com/private","password":"s3cret"}`)
+		resp := do(http.MethodGet, link, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Location"))
+
+		unlock := func(password string) *http.Response {
+			form := url.Values{"password": {password}}
+			r, err := http.NewRequest(http.MethodPost, ts.URL+link, strings.NewReader(form.Encode()))
+			require.NoError(t, err)
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			resp, err := client.Do(r)
+			require.NoError(t, err)
+			resp.Body.Close()
+			return resp
+		}
+		assert.Equal(t, http.StatusUnauthorized, unlock("guess").StatusCode)
+		resp = unlock("s3cret")
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		assert.Equal(t, "https://example.com/private", resp.Header.Get("Location"))
+
+		resp = do(http.MethodPatch, "/api/user/urls"+link, `{"password":""}`)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.NotContains(t, string(body), "$2a$")
+		resp = do(http.MethodGet, link, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+		// without a password there is nothing to unlock
+		resp = unlock("")
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Location"))
+	})
+	t.Run("max clicks", func(t *testing.T) {
+		link := shorten(`{"url":"https://example.com/invite","max_clicks":5}`)
+
+		var wg sync.WaitGroup
+		var redirects, gone atomic.Int32
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp := do(http.MethodGet, link, "")
+				resp.Body.Close()
+				switch resp.StatusCode {
+				case http.StatusFound:
+					redirects.Add(1)
+				case http.StatusGone:
+					gone.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(5), redirects.Load())
+		assert.Equal(t, int32(15), gone.Load())
+
+		resp := do(http.MethodPatch, "/api/user/urls"+link, `{"max_clicks":1}`)
+		resp.Body.Close()
+		resp = do(http.MethodGet, link, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+		resp = do(http.MethodPatch, "/api/user/urls"+link, `{"max_clicks":null}`)
+		resp.Body.Close()
+		for i := 0; i < 3; i++ {
+			resp = do(http.MethodGet, link, "")
+			resp.Body.Close()
+			assert.Equal(t, http.StatusFound, resp.StatusCode, "the link has no click limit anymore")
+		}
+	})
+	t.Run("activation window", func(t *testing.T) {
+		future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+		link := shorten(`{"url":"https://example.com/launch","not_before":"` + future + `"}`)
+		resp := do(http.MethodGet, link, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+		resp = do(http.MethodPatch, "/api/user/urls"+link, `{"not_before":"`+past+`","not_after":"`+future+`"}`)
+		resp.Body.Close()
+		resp = do(http.MethodGet, link, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+		ended := shorten(`{"url":"https://example.com/ended","not_after":"` + past + `"}`)
+		resp = do(http.MethodGet, ended, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusGone, resp.StatusCode)
+
+		// the window is checked against the stored end
+		resp = do(http.MethodPatch, "/api/user/urls"+link, `{"not_after":"`+past+`"}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+		resp = do(http.MethodPatch, "/api/user/urls"+ended, `{"not_after":null}`)
+		resp.Body.Close()
+		resp = do(http.MethodGet, ended, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+	})
+	t.Run("device rules", func(t *testing.T) {
+		link := shorten(`{"url":"https://example.com/app","device_rules":{"rules":[
+			{"os":"ios","url":"https://apps.apple.com/app/id1"},
+			{"os":"android","bot":false,"url":"https://play.google.com/store/apps/details?id=app"}]}}`)
+
+		for ua, expected := range map[string]string{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148": "https://apps.apple.com/app/id1",
+			"Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36":        "https://play.google.com/store/apps/details?id=app",
+			"Mozilla/5.0 (Linux; Android 14) Googlebot/2.1":                        "https://example.com/app",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64)":                            "https://example.com/app",
+		} {
+			r, err := http.NewRequest(http.MethodGet, ts.URL+link, nil)
+			require.NoError(t, err)
+			r.Header.Set("User-Agent", ua)
+			resp, err := client.Do(r)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, expected, resp.Header.Get("Location"), ua)
+			assert.Equal(t, "User-Agent", resp.Header.Get("Vary"))
+		}
+
+		resp := do(http.MethodPatch, "/api/user/urls"+link, `{"device_rules":{"rules":[{"os":"beos","url":"https://example.com/"}]}}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+		// an empty rule set does not make the redirect vary
+		resp = do(http.MethodPatch, "/api/user/urls"+link, `{"device_rules":{"rules":[]}}`)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = do(http.MethodGet, link, "")
+		resp.Body.Close()
+		assert.Equal(t, "https://example.com/app", resp.Header.Get("Location"))
+		assert.Empty(t, resp.Header.Get("Vary"))
+	})
+	t.Run("split", func(t *testing.T) {
+		link := shorten(`{"url":"https://example.com/landing","split":{"targets":[
+			{"name":"a","url":"https://example.com/a","weight":1},
+			{"name":"b","url":"https://example.com/b","weight":3}]}}`)
+
+		resp := do(http.MethodGet, link, "")
+		resp.Body.Close()
+		first := resp.Header.Get("Location")
+		assert.Contains(t, []string{"https://example.com/a", "https://example.com/b"}, first)
+		cookies := resp.Cookies()
+		require.Len(t, cookies, 1)
+
+		for i := 0; i < 5; i++ {
+			r, err := http.NewRequest(http.MethodGet, ts.URL+link, nil)
+			require.NoError(t, err)
+			r.AddCookie(cookies[0])
+			resp, err := client.Do(r)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, first, resp.Header.Get("Location"), "visitors stick to their variant")
+		}
+
+		variant := strings.TrimPrefix(first, "https://example.com/")
+		assert.Eventually(t, func() bool {
+			resp := do(http.MethodGet, "/api/user/urls"+link+"/stats", "")
+			defer resp.Body.Close()
+			var stats models.ClickStats
+			if json.NewDecoder(resp.Body).Decode(&stats) != nil {
+				return false
+			}
+			return stats.Total == 6 && stats.Variants[variant] == 6
+		}, time.Second, 10*time.Millisecond)
+
+		resp = do(http.MethodPatch, "/api/user/urls"+link, `{"split":{"targets":[{"url":"https://example.com/a","weight":0}]}}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+	t.Run("geo", func(t *testing.T) {
+		link := shorten(`{"url":"https://example.com/shop","geo":{"countries":{"de":"https://example.de/shop"}}}`)
+
+		// without a GeoIP database the country is unknown
+		resp := do(http.MethodGet, link, "")
+		resp.Body.Close()
+		assert.Equal(t, "https://example.com/shop", resp.Header.Get("Location"))
+
+		resp = do(http.MethodPatch, "/api/user/urls"+link, `{"geo":{"countries":{"germany":"https://example.de/"}}}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+	t.Run("preview", func(t *testing.T) {
+		link := shorten(`{"url":"https://example.com/untrusted","title":"<b>Docs</b>"}`)
+
+		for _, path := range []string{link + "+", link + "?preview"} {
+			resp := do(http.MethodGet, path, "")
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+			assert.Empty(t, resp.Header.Get("Location"))
+			assert.Contains(t, string(body), "https://example.com/untrusted")
+			assert.Contains(t, string(body), "&lt;b&gt;Docs&lt;/b&gt;")
+			assert.Contains(t, string(body), "Created on ")
+		}
+
+		resp := do(http.MethodPatch, "/api/user/urls"+link, `{"interstitial":true}`)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = do(http.MethodGet, link, "")
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Location"))
+
+		// the continue link of the page carries a signed token
+		match := regexp.MustCompile(`href="([^"]+)"`).FindStringSubmatch(string(body))
+		require.Len(t, match, 2)
+		next := html.UnescapeString(match[1])
+		assert.True(t, strings.HasPrefix(next, link+"?continue="), next)
+		resp = do(http.MethodGet, next, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+		assert.Equal(t, "https://example.com/untrusted", resp.Header.Get("Location"))
+
+		resp = do(http.MethodGet, link+"?continue=9999999999.forged", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Location"))
+	})
+	t.Run("qr code", func(t *testing.T) {
+		resp := do(http.MethodPost, "/api/shorten/?qr=true", `{"url":"https://example.com/poster"}`)
+		var reply models.JSONResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+		resp.Body.Close()
+		assert.Equal(t, reply.Result+"/qr", reply.QR)
+		link := strings.TrimPrefix(reply.Result, "http://localhost:8080")
+
+		resp = do(http.MethodGet, link+"/qr?size=128", "")
+		_, err := png.Decode(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+
+		resp = do(http.MethodGet, link+"/qr?format=svg&level=h", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "image/svg+xml", resp.Header.Get("Content-Type"))
+
+		resp = do(http.MethodGet, link+"/qr?size=10000", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = do(http.MethodGet, "/missing/qr", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		id := strings.TrimPrefix(link, "/")
+		require.NoError(t, ts.storage.SetBlocked(context.Background(), []string{id}, true))
+		resp = do(http.MethodGet, link+"/qr", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		ts.storage.DeleteURLS(context.Background(), "testUserID", []string{id}, zap.NewNop().Sugar())
+		resp = do(http.MethodGet, link+"/qr", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusGone, resp.StatusCode)
+	})
+	t.Run("metadata", func(t *testing.T) {
+		link := shorten(`{"url":"https://example.com/spring-sale","title":"Spring sale",
+			"description":"Poster campaign","tags":["Campaign"," print ","campaign"],"notes":"ends in May"}`)
+		shorten(`{"url":"https://example.com/other","tags":["campaign"]}`)
+
+		list := func(query string) []models.UsersURLS {
+			resp := do(http.MethodGet, "/api/user/urls"+query, "")
+			defer resp.Body.Close()
+			var urls []models.UsersURLS
+			if resp.StatusCode == http.StatusOK {
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&urls))
+			}
+			return urls
+		}
+
+		urls := list("?tag=campaign&tag=PRINT")
+		require.Len(t, urls, 1)
+		assert.Equal(t, "http://localhost:8080"+link, urls[0].ShortURL)
+		assert.Equal(t, []string{"campaign", "print"}, urls[0].Tags)
+		assert.Equal(t, "Poster campaign", *urls[0].Description)
+		assert.Equal(t, "ends in May", *urls[0].Notes)
+		assert.Len(t, list("?tag=campaign"), 2)
+
+		resp := do(http.MethodPatch, "/api/user/urls"+link, `{"tags":[]}`)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, list("?tag=print"))
+
+		resp = do(http.MethodPatch, "/api/user/urls"+link, `{"title":"`+strings.Repeat("x", 201)+`"}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+	t.Run("search", func(t *testing.T) {
+		first := shorten(`{"url":"https://example.com/winter-boots","title":"Winter boots"}`)
+		second := shorten(`{"url":"https://example.com/boots?color=black","tags":["winter"]}`)
+
+		search := func(query string) (int, models.URLSearchResult) {
+			resp := do(http.MethodGet, "/api/user/urls/search?"+query, "")
+			defer resp.Body.Close()
+			var result models.URLSearchResult
+			if resp.StatusCode == http.StatusOK {
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+			}
+			return resp.StatusCode, result
+		}
+
+		status, result := search("q=winter+boot")
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, 2, result.Total)
+		assert.Equal(t, "http://localhost:8080"+first, result.Results[0].ShortURL, "title matches rank first")
+		assert.Equal(t, "http://localhost:8080"+second, result.Results[1].ShortURL)
+
+		_, result = search("q=winter+boot&limit=1&offset=1")
+		assert.Equal(t, 2, result.Total)
+		require.Len(t, result.Results, 1)
+		assert.Equal(t, "http://localhost:8080"+second, result.Results[0].ShortURL)
+
+		status, _ = search("q=+-+")
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _ = search("q=boots&limit=1000")
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"mime"
+	"net/http"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// maxStreamLineSize bounds a single NDJSON record of the streaming endpoint.
+const maxStreamLineSize = 1 << 20
+
+// ShortenURLStream shortens an NDJSON stream of URLBatchRequest records. The
+// records are stored in chunks as they arrive and a URLRBatchResponse line is
+// written back for every record, in input order, so arbitrarily large inputs
+// never have to be held in memory.
+func (h *handler) ShortenURLStream(w http.ResponseWriter, r *http.Request) {
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != ndjsonContentType {
+		http.Error(w, "Content-Type must be "+ndjsonContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+	cntx := context.WithValue(r.Context(), models.CtxKey("userID"), userID)
+
+	// results are written while the request body is still being read
+	rc := http.NewResponseController(w)
+	if err = rc.EnableFullDuplex(); err != nil {
+		h.logger.Errorw("Failed to enable full duplex for stream", "error", err)
+	}
+
+	// the status is sent implicitly with the first result: writing it before
+	// reading the body would keep clients expecting 100-continue from sending it
+	w.Header().Set("Content-Type", ndjsonContentType)
+
+	withQR := wantQR(r)
+	enc := json.NewEncoder(w)
+	chunk := make([]models.URLBatchRequest, 0, h.streamChunkSize)
+
+	flush := func() error {
+		if len(chunk) > 0 {
+			// the quota is checked per chunk, entries beyond it are rejected
+			usage, err := h.quotaUsage(cntx, userID)
+			if err != nil {
+				return err
+			}
+			results, err := h.shortenBatch(cntx, chunk, usage.MaxLinks)
+			if err != nil {
+				return err
+			}
+			chunk = chunk[:0]
+			if withQR {
+				addQRURLs(results)
+			}
+			for _, res := range results {
+				if err := enc.Encode(res); err != nil {
+					return err
+				}
+			}
+		}
+		return rc.Flush()
+	}
+
+	fail := func(err error) {
+		h.logger.Errorw("Failed to shorten URLs stream", "error", err)
+		enc.Encode(models.URLRBatchResponse{Status: models.BatchStatusFailed, Error: err.Error()})
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineSize)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var req models.URLBatchRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			// keep results in input order
+			if err := flush(); err != nil {
+				fail(err)
+				return
+			}
+			enc.Encode(models.URLRBatchResponse{Status: models.BatchStatusInvalid, Error: "malformed record: " + err.Error()})
+			continue
+		}
+
+		chunk = append(chunk, req)
+		if len(chunk) >= h.streamChunkSize {
+			if err := flush(); err != nil {
+				fail(err)
+				return
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		fail(err)
+		return
+	}
+	if err := scanner.Err(); err != nil {
+		fail(err)
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GetUTM returns the UTM template applied to the links of the user.
+func (h *handler) GetUTM(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	utm, err := h.repository.GetUserUTM(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to get UTM template", "error", err)
+		return
+	}
+	if utm == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(utm); err != nil {
+		h.logger.Errorw("Failed to write response", "error", err)
+	}
+}
+
+// SetUTM replaces the UTM template applied to the links of the user.
+func (h *handler) SetUTM(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var utm models.UTMTemplate
+	err := json.NewDecoder(r.Body).Decode(&utm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = h.repository.SetUserUTM(r.Context(), userID, &utm); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to set UTM template", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUTM removes the UTM template of the user.
+func (h *handler) DeleteUTM(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.repository.SetUserUTM(r.Context(), userID, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.logger.Errorw("Failed to delete UTM template", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyUTM adds the UTM parameters of link and its owner to dest. Parameters
+// already present in dest are kept, the stored destination is not changed.
+func (h *handler) applyUTM(ctx context.Context, link models.URL, dest string) string {
+	var utm models.UTMTemplate
+	if link.Options.UTM != nil {
+		utm = *link.Options.UTM
+	}
+	if link.UserID != "" {
+		owner, err := h.repository.GetUserUTM(ctx, link.UserID)
+		if err != nil {
+			h.logger.Errorw("Failed to get UTM template", "error", err)
+		} else if owner != nil {
+			utm.Fill(*owner)
+		}
+	}
+	if utm == (models.UTMTemplate{}) {
+		return dest
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil {
+		return dest
+	}
+	own := u.Query()
+	params := url.Values{}
+	for name, value := range utm.Params() {
+		if _, ok := own[name]; value != "" && !ok {
+			params.Set(name, strings.ReplaceAll(value, "{id}", link.ShortURL))
+		}
+	}
+	if len(params) == 0 {
+		return dest
+	}
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += params.Encode()
+	return u.String()
+}
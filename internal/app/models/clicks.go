@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Click is a single redirect of a short link.
+type Click struct {
+	ShortURL string
+	// Variant is the name of the split target the visitor was sent to.
+	Variant string
+	// Country is the ISO code of the country of the visitor, empty if
+	// unknown.
+	Country string
+	Time    time.Time
+}
+
+type ClickStats struct {
+	Total     int            `json:"total"`
+	Variants  map[string]int `json:"variants,omitempty"`
+	Countries map[string]int `json:"countries,omitempty"`
+}
+
+// Add counts click in s.
+func (s *ClickStats) Add(click Click) {
+	s.Total++
+	if click.Variant != "" {
+		if s.Variants == nil {
+			s.Variants = make(map[string]int)
+		}
+		s.Variants[click.Variant]++
+	}
+	if click.Country != "" {
+		if s.Countries == nil {
+			s.Countries = make(map[string]int)
+		}
+		s.Countries[click.Country]++
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+// Collection is a named group of links of a user, every link is in at most
+// one collection.
+type Collection struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	UserID string `json:"-"`
+	// Links counts the links in the collection, it is only set when
+	// collections are listed.
+	Links int `json:"links"`
+}
+
+type CollectionRequest struct {
+	Name string `json:"name"`
+}
+
+// MoveURLSRequest moves URLs to Collection, an empty collection takes them
+// out of theirs.
+type MoveURLSRequest struct {
+	Collection string   `json:"collection"`
+	URLs       []string `json:"urls"`
+}
@@ -0,0 +1,16 @@
+package models
+
+// DeviceRule sends the clients matching all of its non-empty conditions to
+// URL.
+type DeviceRule struct {
+	OS     string `json:"os,omitempty"`
+	Device string `json:"device,omitempty"`
+	Bot    *bool  `json:"bot,omitempty"`
+	URL    string `json:"url"`
+}
+
+// DeviceRuleSet is evaluated in order, clients matching no rule are sent to
+// the destination of the link.
+type DeviceRuleSet struct {
+	Rules []DeviceRule `json:"rules"`
+}
@@ -0,0 +1,16 @@
+package models
+
+// GeoTargets sends visitors from the listed countries, by ISO 3166-1 alpha-2
+// code, to their own destination. Empty targets remove the geo routing.
+type GeoTargets struct {
+	Countries map[string]string `json:"countries"`
+}
+
+// Target returns the destination for visitors from country.
+func (g *GeoTargets) Target(country string) (string, bool) {
+	if g == nil || country == "" {
+		return "", false
+	}
+	target, ok := g.Countries[country]
+	return target, ok
+}
@@ -0,0 +1,54 @@
+package models
+
+// LinkMeta describes a link to its owner, it does not change where the link
+// leads to.
+type LinkMeta struct {
+	// Title is also shown on the preview page of the link.
+	Title       *string `json:"title,omitempty"`
+	Description *string `json:"description,omitempty"`
+	// Tags are lower case, an empty list removes them.
+	Tags  []string `json:"tags,omitempty"`
+	Notes *string  `json:"notes,omitempty"`
+}
+
+// Merge overrides the fields of m that are set in update.
+func (m *LinkMeta) Merge(update LinkMeta) {
+	if update.Title != nil {
+		m.Title = update.Title
+	}
+	if update.Description != nil {
+		m.Description = update.Description
+	}
+	if update.Tags != nil {
+		m.Tags = update.Tags
+	}
+	if update.Notes != nil {
+		m.Notes = update.Notes
+	}
+}
+
+// HasTags reports whether m is tagged with every one of tags.
+func (m LinkMeta) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range m.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// URLFilter selects the links GetUsersURLS returns, the zero value selects
+// all of them.
+type URLFilter struct {
+	// Tags the links must all be tagged with.
+	Tags []string
+	// Collection is the ID of the collection the links must be in.
+	Collection string
+}
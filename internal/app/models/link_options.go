@@ -0,0 +1,110 @@
+package models
+
+import "time"
+
+// LinkOptions are the per link settings. Unset fields fall back to the server
+// defaults, so that changing a default affects every link not overriding it.
+type LinkOptions struct {
+	LinkMeta
+	// Interstitial shows the preview page instead of redirecting, visitors
+	// continue to the destination from there.
+	Interstitial *bool `json:"interstitial,omitempty"`
+	// RedirectCode is the status code GetFullURL redirects with.
+	RedirectCode *int `json:"redirect_code,omitempty"`
+	// PassQuery appends the query parameters of the short link request to
+	// the destination.
+	PassQuery *bool `json:"pass_query,omitempty"`
+	// Prefix makes /{id}/rest/of/path redirect to the destination with the
+	// rest of the path appended.
+	Prefix *bool `json:"prefix,omitempty"`
+	// UTM overrides the fields of the UTM template of the link owner.
+	UTM *UTMTemplate `json:"utm,omitempty"`
+	// MaxClicks is the number of times the link may be followed. Setting it
+	// on an existing link resets the number of clicks left.
+	MaxClicks *int `json:"max_clicks,omitempty"`
+	// NotBefore and NotAfter bound the time the link can be followed in.
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	// DeviceRules pick the destination by the User-Agent of the client, an
+	// empty rule set removes them.
+	DeviceRules *DeviceRuleSet `json:"device_rules,omitempty"`
+	// Geo sends visitors not matched by a device rule to the destination of
+	// their country.
+	Geo *GeoTargets `json:"geo,omitempty"`
+	// Split sends the remaining visitors to one of several weighted
+	// destinations.
+	Split *SplitSet `json:"split,omitempty"`
+	// Password is only accepted in requests, handlers replace it by
+	// PasswordHash before the options are stored.
+	Password *string `json:"password,omitempty"`
+	// PasswordHash is the bcrypt hash of the password protecting the link,
+	// an empty hash removes the protection. It is stored apart from the
+	// other options and never serialized.
+	PasswordHash *string `json:"-"`
+	// Clear lists the options an update removes by their JSON names, see
+	// ClearableOptions.
+	Clear []string `json:"-"`
+}
+
+// ClearableOptions are the options an update may remove by setting them to
+// null, the link then falls back to the server default or has no limit.
+var ClearableOptions = []string{"redirect_code", "max_clicks", "not_before", "not_after"}
+
+// Protected reports whether a password is needed to follow the link.
+func (o LinkOptions) Protected() bool {
+	return o.PasswordHash != nil && *o.PasswordHash != ""
+}
+
+// Merge overrides the fields of o that are set in update and removes those
+// it clears.
+func (o *LinkOptions) Merge(update LinkOptions) {
+	o.LinkMeta.Merge(update.LinkMeta)
+	if update.Interstitial != nil {
+		o.Interstitial = update.Interstitial
+	}
+	if update.RedirectCode != nil {
+		o.RedirectCode = update.RedirectCode
+	}
+	if update.PassQuery != nil {
+		o.PassQuery = update.PassQuery
+	}
+	if update.Prefix != nil {
+		o.Prefix = update.Prefix
+	}
+	if update.UTM != nil {
+		o.UTM = update.UTM
+	}
+	if update.MaxClicks != nil {
+		o.MaxClicks = update.MaxClicks
+	}
+	if update.NotBefore != nil {
+		o.NotBefore = update.NotBefore
+	}
+	if update.NotAfter != nil {
+		o.NotAfter = update.NotAfter
+	}
+	if update.DeviceRules != nil {
+		o.DeviceRules = update.DeviceRules
+	}
+	if update.Geo != nil {
+		o.Geo = update.Geo
+	}
+	if update.Split != nil {
+		o.Split = update.Split
+	}
+	if update.PasswordHash != nil {
+		o.PasswordHash = update.PasswordHash
+	}
+	for _, key := range update.Clear {
+		switch key {
+		case "redirect_code":
+			o.RedirectCode = nil
+		case "max_clicks":
+			o.MaxClicks = nil
+		case "not_before":
+			o.NotBefore = nil
+		case "not_after":
+			o.NotAfter = nil
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package models
+
+// Quota limits the links of a user, zero means unlimited.
+type Quota struct {
+	MaxLinks     int `json:"max_links"`
+	MaxBatchSize int `json:"max_batch_size"`
+}
+
+type QuotaUsage struct {
+	Quota
+	Links int `json:"links"`
+}
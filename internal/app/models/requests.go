@@ -2,9 +2,13 @@ package models
 
 type JSONRequest struct {
 	URL string `json:"url"`
+	LinkOptions
 }
 
 type URLBatchRequest struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	// Alias is an optional custom short link ID.
+	Alias string `json:"alias,omitempty"`
+	LinkOptions
 }
@@ -2,14 +2,45 @@ package models
 
 type JSONResponse struct {
 	Result string `json:"result"`
+	// QR is the URL of the QR code of Result, set on request.
+	QR string `json:"qr,omitempty"`
 }
 
+// Outcomes of a single batch entry.
+const (
+	BatchStatusCreated  = "created"
+	BatchStatusExisting = "existing"
+	BatchStatusInvalid  = "invalid"
+	// BatchStatusFailed terminates a stream whose remaining records could
+	// not be processed.
+	BatchStatusFailed = "failed"
+)
+
 type URLRBatchResponse struct {
 	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
+	ShortURL      string `json:"short_url,omitempty"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	QR            string `json:"qr,omitempty"`
+}
+
+type LinkResponse struct {
+	ShortURL    string      `json:"short_url"`
+	OriginalURL string      `json:"original_url"`
+	Options     LinkOptions `json:"options"`
 }
 
 type UsersURLS struct {
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
+	// Collection is the ID of the collection of the link.
+	Collection string `json:"collection,omitempty"`
+	LinkMeta
+}
+
+// URLSearchResult is a page of search results, best match first, and the
+// total number of matching links.
+type URLSearchResult struct {
+	Total   int         `json:"total"`
+	Results []UsersURLS `json:"results"`
 }
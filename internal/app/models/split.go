@@ -0,0 +1,16 @@
+package models
+
+// SplitTarget is a destination of an A/B split, chosen by visitors in
+// proportion to its weight.
+type SplitTarget struct {
+	// Name identifies the variant in the click statistics.
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// SplitSet replaces the destination of a link by its weighted targets, an
+// empty set removes the split.
+type SplitSet struct {
+	Targets []SplitTarget `json:"targets"`
+}
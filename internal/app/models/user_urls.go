@@ -1,7 +1,16 @@
 package models
 
+import "time"
+
 type URL struct {
 	ShortURL    string
 	OriginalURL string
 	UserID      string
+	// Blocked links are not redirected, a warning page is shown instead.
+	Blocked bool
+	Options LinkOptions
+	// CreatedAt is zero for links created before it was recorded.
+	CreatedAt time.Time
+	// Collection is the ID of the collection of the link, if any.
+	Collection string
 }
@@ -0,0 +1,41 @@
+package models
+
+// UTMTemplate holds the UTM parameters added to a destination on redirect.
+// "{id}" in a value is replaced by the short link ID.
+type UTMTemplate struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// Params returns the query parameters of the template by name.
+func (t UTMTemplate) Params() map[string]string {
+	return map[string]string{
+		"utm_source":   t.Source,
+		"utm_medium":   t.Medium,
+		"utm_campaign": t.Campaign,
+		"utm_term":     t.Term,
+		"utm_content":  t.Content,
+	}
+}
+
+// Fill sets the empty fields of t from fallback.
+func (t *UTMTemplate) Fill(fallback UTMTemplate) {
+	if t.Source == "" {
+		t.Source = fallback.Source
+	}
+	if t.Medium == "" {
+		t.Medium = fallback.Medium
+	}
+	if t.Campaign == "" {
+		t.Campaign = fallback.Campaign
+	}
+	if t.Term == "" {
+		t.Term = fallback.Term
+	}
+	if t.Content == "" {
+		t.Content = fallback.Content
+	}
+}
@@ -0,0 +1,195 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBlockedDestination = errors.New("destination is blocked")
+	ErrRedirectLoop       = errors.New("destination points to the shortener itself")
+)
+
+// Engine decides whether a destination may be shortened. Destinations are
+// matched against a blocklist file with one entry per line: a domain, which
+// also blocks its subdomains, or a regular expression prefixed with "re:"
+// matched against the whole URL. Empty lines and lines starting with "#" are
+// ignored.
+type Engine struct {
+	mu       sync.RWMutex
+	domains  map[string]struct{}
+	patterns []*regexp.Regexp
+	modTime  time.Time
+
+	path    string
+	ownHost string
+	logger  *zap.SugaredLogger
+}
+
+// NewEngine loads the blocklist at path, if any, and rejects destinations on
+// the host of baseAddress, which would redirect to the shortener itself.
+func NewEngine(path string, baseAddress string, logger *zap.SugaredLogger) (*Engine, error) {
+	e := &Engine{
+		domains: make(map[string]struct{}),
+		path:    path,
+		ownHost: hostOf(baseAddress),
+		logger:  logger,
+	}
+
+	if path != "" {
+		if err := e.Reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// Check returns an error wrapping ErrBlockedDestination or ErrRedirectLoop if
+// rawURL must not be shortened.
+func (e *Engine) Check(rawURL string) error {
+	if e.ownHost != "" && hostOf(rawURL) == e.ownHost {
+		return ErrRedirectLoop
+	}
+	return e.CheckBlocklist(rawURL)
+}
+
+// CheckBlocklist only matches rawURL against the blocklist.
+func (e *Engine) CheckBlocklist(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for host != "" {
+		if _, ok := e.domains[host]; ok {
+			return fmt.Errorf("%w: domain %s", ErrBlockedDestination, host)
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			break
+		}
+		host = parent
+	}
+
+	for _, re := range e.patterns {
+		if re.MatchString(rawURL) {
+			return fmt.Errorf("%w: matches %s", ErrBlockedDestination, re)
+		}
+	}
+
+	return nil
+}
+
+// Reload reads the blocklist file again. On error the current list is kept.
+func (e *Engine) Reload() error {
+	f, err := os.Open(e.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	domains := make(map[string]struct{})
+	var patterns []*regexp.Regexp
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if expr, ok := strings.CutPrefix(entry, "re:"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("blocklist line %d: %w", line, err)
+			}
+			patterns = append(patterns, re)
+			continue
+		}
+		domains[strings.TrimSuffix(strings.ToLower(entry), ".")] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.domains = domains
+	e.patterns = patterns
+	e.modTime = info.ModTime()
+	e.mu.Unlock()
+
+	return nil
+}
+
+// Watch reloads the blocklist whenever its modification time changes,
+// checking every interval until ctx is done.
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	if e.path == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(e.path)
+			if err != nil {
+				e.logger.Errorw("Failed to stat blocklist", "path", e.path, "error", err)
+				continue
+			}
+
+			e.mu.RLock()
+			changed := !info.ModTime().Equal(e.modTime)
+			e.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err := e.Reload(); err != nil {
+				e.logger.Errorw("Failed to reload blocklist", "path", e.path, "error", err)
+				continue
+			}
+			e.logger.Infow("Blocklist reloaded", "path", e.path)
+		}
+	}
+}
+
+// hostOf returns the lowercased host of rawURL without the default port of
+// its scheme, which is how normalized destinations store it.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	host := strings.ToLower(u.Host)
+	switch {
+	case u.Scheme == "http" && strings.HasSuffix(host, ":80"):
+		host = strings.TrimSuffix(host, ":80")
+	case u.Scheme == "https" && strings.HasSuffix(host, ":443"):
+		host = strings.TrimSuffix(host, ":443")
+	}
+	return host
+}
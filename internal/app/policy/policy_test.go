@@ -0,0 +1,36 @@
+package policy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEngine_Check(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# phishing\nevil.com\n\nre:^https?://[^/]+/wp-login\\.php\n"), 0644))
+
+	e, err := NewEngine(path, "http://localhost:8080", zap.NewNop().Sugar())
+	require.NoError(t, err)
+
+	assert.NoError(t, e.Check("https://practicum.yandex.ru/"))
+	assert.NoError(t, e.Check("https://notevil.com/"))
+	assert.NoError(t, e.Check("http://localhost:3000/"))
+	assert.ErrorIs(t, e.Check("https://evil.com/"), ErrBlockedDestination)
+	assert.ErrorIs(t, e.Check("https://login.evil.com/path"), ErrBlockedDestination)
+	assert.ErrorIs(t, e.Check("https://ya.ru/wp-login.php"), ErrBlockedDestination)
+	assert.ErrorIs(t, e.Check("http://localhost:8080/abc"), ErrRedirectLoop)
+
+	require.NoError(t, os.WriteFile(path, []byte("ya.ru\n"), 0644))
+	require.NoError(t, e.Reload())
+
+	assert.NoError(t, e.Check("https://evil.com/"))
+	assert.ErrorIs(t, e.Check("https://ya.ru/"), ErrBlockedDestination)
+
+	require.NoError(t, os.WriteFile(path, []byte("re:(\n"), 0644))
+	assert.Error(t, e.Reload())
+	assert.ErrorIs(t, e.Check("https://ya.ru/"), ErrBlockedDestination, "a broken list keeps the previous one")
+}
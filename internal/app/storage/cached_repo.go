@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"github.com/FeelDat/urlshort/internal/lrucache"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// cachedStorage decorates a Repository with an LRU cache of short link
+// resolutions. Unknown links are cached for negativeTTL so that scans of
+// random IDs do not reach the underlying storage on every request.
+type cachedStorage struct {
+	Repository
+	cache *lrucache.Cache[string, cachedLink]
+	// utms caches the UTM templates of link owners, read on every redirect.
+	utms        *lrucache.Cache[string, *models.UTMTemplate]
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+type cachedLink struct {
+	link models.URL
+	err  error
+}
+
+// NewCachedStorage wraps repo with a redirect cache holding at most size
+// entries. A non-positive size disables caching and returns repo unchanged.
+func NewCachedStorage(repo Repository, size int, ttl time.Duration, negativeTTL time.Duration) Repository {
+	if size <= 0 {
+		return repo
+	}
+
+	return &cachedStorage{
+		Repository:  repo,
+		cache:       lrucache.New[string, cachedLink](size),
+		utms:        lrucache.New[string, *models.UTMTemplate](size),
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+}
+
+func (s *cachedStorage) GetFullURL(ctx context.Context, shortLink string) (models.URL, error) {
+	if v, ok := s.cache.Get(shortLink); ok {
+		return v.link, v.err
+	}
+
+	link, err := s.Repository.GetFullURL(ctx, shortLink)
+	switch {
+	case err == nil, errors.Is(err, ErrLinkDeleted):
+		s.cache.Set(shortLink, cachedLink{link: link, err: err}, s.ttl)
+	case errors.Is(err, ErrLinkNotFound):
+		if s.negativeTTL > 0 {
+			s.cache.Set(shortLink, cachedLink{err: err}, s.negativeTTL)
+		}
+	}
+
+	return link, err
+}
+
+func (s *cachedStorage) ShortenURL(ctx context.Context, fullLink string, opts models.LinkOptions, maxLinks int) (string, error) {
+	shortURL, err := s.Repository.ShortenURL(ctx, fullLink, opts, maxLinks)
+	if shortURL != "" {
+		s.cache.Delete(shortURL)
+	}
+	return shortURL, err
+}
+
+func (s *cachedStorage) ShortenURLBatch(ctx context.Context, batch []models.URLBatchRequest, baseAddr string, maxLinks int) ([]models.URLRBatchResponse, error) {
+	responses, err := s.Repository.ShortenURLBatch(ctx, batch, baseAddr, maxLinks)
+	for _, resp := range responses {
+		s.cache.Delete(strings.TrimPrefix(resp.ShortURL, baseAddr+"/"))
+	}
+	return responses, err
+}
+
+func (s *cachedStorage) DeleteURLS(ctx context.Context, userID string, shortLinks []string, logger *zap.SugaredLogger) {
+	s.Repository.DeleteURLS(ctx, userID, shortLinks, logger)
+	for _, shortLink := range shortLinks {
+		s.cache.Delete(shortLink)
+	}
+}
+
+func (s *cachedStorage) UpdateURL(ctx context.Context, userID string, shortLink string, opts models.LinkOptions) (models.URL, error) {
+	link, err := s.Repository.UpdateURL(ctx, userID, shortLink, opts)
+	s.cache.Delete(shortLink)
+	return link, err
+}
+
+func (s *cachedStorage) GetUserUTM(ctx context.Context, userID string) (*models.UTMTemplate, error) {
+	if utm, ok := s.utms.Get(userID); ok {
+		return utm, nil
+	}
+
+	utm, err := s.Repository.GetUserUTM(ctx, userID)
+	if err == nil {
+		s.utms.Set(userID, utm, s.ttl)
+	}
+	return utm, err
+}
+
+func (s *cachedStorage) SetUserUTM(ctx context.Context, userID string, utm *models.UTMTemplate) error {
+	err := s.Repository.SetUserUTM(ctx, userID, utm)
+	s.utms.Delete(userID)
+	return err
+}
+
+func (s *cachedStorage) SetBlocked(ctx context.Context, shortLinks []string, blocked bool) error {
+	err := s.Repository.SetBlocked(ctx, shortLinks, blocked)
+	for _, shortLink := range shortLinks {
+		s.cache.Delete(shortLink)
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRepository resolves links from a map and counts lookups. A non-zero
+// latency emulates the database round trips of dbStorage.GetFullURL.
+type countingRepository struct {
+	Repository
+	links   map[string]string
+	latency time.Duration
+	lookups atomic.Int64
+}
+
+func (r *countingRepository) GetFullURL(ctx context.Context, shortLink string) (models.URL, error) {
+	r.lookups.Add(1)
+	if r.latency > 0 {
+		time.Sleep(r.latency)
+	}
+	v, ok := r.links[shortLink]
+	if !ok {
+		return models.URL{}, ErrLinkNotFound
+	}
+	if v == "" {
+		return models.URL{}, ErrLinkDeleted
+	}
+	return models.URL{ShortURL: shortLink, OriginalURL: v}, nil
+}
+
+func (r *countingRepository) DeleteURLS(ctx context.Context, userID string, shortLinks []string, logger *zap.SugaredLogger) {
+	for _, l := range shortLinks {
+		r.links[l] = ""
+	}
+}
+
+func (r *countingRepository) ShortenURL(ctx context.Context, fullLink string, opts models.LinkOptions, maxLinks int) (string, error) {
+	r.links["new"] = fullLink
+	return "new", nil
+}
+
+func TestCachedStorage(t *testing.T) {
+	ctx := context.Background()
+	repo := &countingRepository{links: map[string]string{"abc": "https://practicum.yandex.ru/"}}
+	cached := NewCachedStorage(repo, 10, time.Minute, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		v, err := cached.GetFullURL(ctx, "abc")
+		require.NoError(t, err)
+		assert.Equal(t, "https://practicum.yandex.ru/", v.OriginalURL)
+	}
+	assert.EqualValues(t, 1, repo.lookups.Load())
+
+	for i := 0; i < 3; i++ {
+		_, err := cached.GetFullURL(ctx, "new")
+		assert.ErrorIs(t, err, ErrLinkNotFound)
+	}
+	assert.EqualValues(t, 2, repo.lookups.Load(), "unknown links should be cached")
+
+	_, err := cached.ShortenURL(ctx, "https://ya.ru/", models.LinkOptions{}, 0)
+	require.NoError(t, err)
+	v, err := cached.GetFullURL(ctx, "new")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ya.ru/", v.OriginalURL, "negative entry should be dropped on create")
+
+	cached.DeleteURLS(ctx, "user", []string{"abc"}, nil)
+	_, err = cached.GetFullURL(ctx, "abc")
+	assert.ErrorIs(t, err, ErrLinkDeleted)
+}
+
+func BenchmarkGetFullURL(b *testing.B) {
+	links := make(map[string]string, 1000)
+	for i := 0; i < 1000; i++ {
+		links[fmt.Sprintf("id%d", i)] = fmt.Sprintf("https://example.com/%d", i)
+	}
+
+	cases := []struct {
+		name string
+		repo func(Repository) Repository
+	}{
+		{name: "uncached", repo: func(r Repository) Repository { return r }},
+		{name: "cached", repo: func(r Repository) Repository { return NewCachedStorage(r, 1000, time.Minute, time.Minute) }},
+	}
+
+	for _, bc := range cases {
+		b.Run(bc.name, func(b *testing.B) {
+			repo := bc.repo(&countingRepository{links: links, latency: 200 * time.Microsecond})
+			ctx := context.Background()
+			for id := range links {
+				_, _ = repo.GetFullURL(ctx, id)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = repo.GetFullURL(ctx, fmt.Sprintf("id%d", i%1000))
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package storage
+
+import "errors"
+
+var (
+	ErrLinkNotFound = errors.New("link does not exist")
+	ErrLinkDeleted  = errors.New("link is deleted")
+	// ErrLinkExhausted is returned by UseClick once a link has been
+	// followed MaxClicks times.
+	ErrLinkExhausted = errors.New("link has no clicks left")
+	// ErrQuotaExceeded is returned by ShortenURL if the user already owns
+	// maxLinks links.
+	ErrQuotaExceeded = errors.New("link quota exceeded")
+	// ErrAliasTaken is returned by ShortenURL if the short link is already
+	// used by another URL.
+	ErrAliasTaken = errors.New("short link is already taken")
+
+	ErrCollectionNotFound = errors.New("collection does not exist")
+	ErrCollectionExists   = errors.New("collection with this name already exists")
+)
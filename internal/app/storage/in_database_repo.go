@@ -2,80 +2,361 @@ package storage
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
 	"errors"
 	"github.com/FeelDat/urlshort/internal/app/models"
 	"github.com/FeelDat/urlshort/internal/utils"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
-	"log"
 	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Repository interface {
-	ShortenURL(ctx context.Context, fullLink string) (string, error)
-	GetFullURL(ctx context.Context, shortLink string) (string, error)
-	ShortenURLBatch(ctx context.Context, batch []models.URLBatchRequest, baseAddr string) ([]models.URLRBatchResponse, error)
-	GetUsersURLS(ctx context.Context, userID string, baseAddr string) ([]models.UsersURLS, error)
+	// ShortenURL and ShortenURLBatch create no links beyond maxLinks links of
+	// the user, 0 means no limit. The check and the insert are atomic.
+	ShortenURL(ctx context.Context, fullLink string, opts models.LinkOptions, maxLinks int) (string, error)
+	GetFullURL(ctx context.Context, shortLink string) (models.URL, error)
+	// ShortenURLBatch marks the entries beyond the quota invalid.
+	ShortenURLBatch(ctx context.Context, batch []models.URLBatchRequest, baseAddr string, maxLinks int) ([]models.URLRBatchResponse, error)
+	// GetUsersURLS returns the links of userID selected by filter.
+	GetUsersURLS(ctx context.Context, userID string, baseAddr string, filter models.URLFilter) ([]models.UsersURLS, error)
+	// SearchURLS returns the limit links of userID after offset matching
+	// every word of query in their original URL, short link, title or tags.
+	SearchURLS(ctx context.Context, userID string, baseAddr string, query string, limit int, offset int) (models.URLSearchResult, error)
 	DeleteURLS(ctx context.Context, userID string, shortLink []string, logger *zap.SugaredLogger)
+	SetBlocked(ctx context.Context, shortLinks []string, blocked bool) error
+	// UpdateURL merges opts into the options of a link owned by userID.
+	UpdateURL(ctx context.Context, userID string, shortLink string, opts models.LinkOptions) (models.URL, error)
+	// UseClick takes one of the clicks left of a link with MaxClicks set and
+	// returns ErrLinkExhausted if there are none.
+	UseClick(ctx context.Context, shortLink string) error
+	RecordClicks(ctx context.Context, clicks []models.Click) error
+	// GetClickStats returns ErrLinkNotFound if userID does not own the link.
+	GetClickStats(ctx context.Context, userID string, shortLink string) (models.ClickStats, error)
+	CountUsersURLS(ctx context.Context, userID string) (int, error)
+	// GetUserQuota returns nil if the user has no quota override.
+	GetUserQuota(ctx context.Context, userID string) (*models.Quota, error)
+	// SetUserQuota stores a quota override, nil removes it.
+	SetUserQuota(ctx context.Context, userID string, quota *models.Quota) error
+	// GetUserUTM returns nil if the user has no UTM template.
+	GetUserUTM(ctx context.Context, userID string) (*models.UTMTemplate, error)
+	// SetUserUTM stores the UTM template of the user, nil removes it.
+	SetUserUTM(ctx context.Context, userID string, utm *models.UTMTemplate) error
+	// CreateCollection returns ErrCollectionExists if the user already has
+	// a collection named name.
+	CreateCollection(ctx context.Context, userID string, name string) (models.Collection, error)
+	GetCollections(ctx context.Context, userID string) ([]models.Collection, error)
+	RenameCollection(ctx context.Context, userID string, collectionID string, name string) error
+	// DeleteCollection keeps the links of the collection, they are just no
+	// longer in one.
+	DeleteCollection(ctx context.Context, userID string, collectionID string) error
+	// MoveURLS moves the links of userID among shortLinks to collectionID,
+	// an empty ID takes them out of their collections.
+	MoveURLS(ctx context.Context, userID string, shortLinks []string, collectionID string) error
+	// CollectionURLS returns the short links in a collection of userID.
+	CollectionURLS(ctx context.Context, userID string, collectionID string) ([]string, error)
+}
+
+// Names of the statements prepared on every pooled connection. pgx accepts
+// a prepared statement name in place of the SQL text.
+const (
+	stmtInsertURL    = "insert_url"
+	stmtGetShortURL  = "get_short_url"
+	stmtGetFullURL   = "get_full_url"
+	stmtGetUsersURLS = "get_users_urls"
+	stmtDeleteURLS   = "delete_urls"
+	stmtInsertBatch  = "insert_batch"
+	stmtGetExisting  = "get_existing"
+	stmtSetBlocked   = "set_blocked"
+	stmtCountURLS    = "count_urls"
+	stmtLockUser     = "lock_user"
+	stmtGetQuota     = "get_quota"
+	stmtSetQuota     = "set_quota"
+	stmtDeleteQuota  = "delete_quota"
+	stmtUpdateURL    = "update_url"
+	stmtUseClick     = "use_click"
+	stmtInsertClicks = "insert_clicks"
+	stmtOwnsURL      = "owns_url"
+	stmtClickStats   = "click_stats"
+	stmtGetUTM       = "get_utm"
+	stmtSetUTM       = "set_utm"
+	stmtDeleteUTM    = "delete_utm"
+	stmtSearchURLS   = "search_urls"
+
+	stmtInsertCollection = "insert_collection"
+	stmtGetCollections   = "get_collections"
+	stmtRenameCollection = "rename_collection"
+	stmtDeleteCollection = "delete_collection"
+	stmtOwnsCollection   = "owns_collection"
+	stmtMoveURLS         = "move_urls"
+	stmtCollectionURLS   = "collection_urls"
+)
+
+// batchChunkSize bounds the number of rows sent in a single batch insert.
+const batchChunkSize = 1000
+
+// maxIDAttempts bounds the generated short link IDs tried by ShortenURL.
+const maxIDAttempts = 3
+
+var preparedStatements = map[string]string{
+	stmtInsertURL:    `INSERT INTO urls(uuid, short_url, original_url, options, password_hash, clicks_left) VALUES($1, $2, $3, $4, $5, $6)`,
+	stmtGetShortURL:  `SELECT short_url FROM urls WHERE original_url = $1`,
+	stmtGetFullURL:   `SELECT original_url, delflag, COALESCE(uuid, ''), blocked, options, password_hash, created_at FROM urls WHERE short_url = $1`,
+	stmtGetUsersURLS: `SELECT short_url, original_url, options, COALESCE(collection_id, '') FROM urls WHERE uuid = $1 AND NOT delflag AND COALESCE(options->'tags', '[]') @> $2::jsonb AND ($3::text = '' OR collection_id = $3)`,
+	stmtDeleteURLS:   `UPDATE urls SET delflag = true WHERE uuid = $1 AND short_url = ANY($2)`,
+	// Rows conflicting on either the original URL or the short link are
+	// skipped, RETURNING only yields the inserted ones.
+	stmtInsertBatch: `INSERT INTO urls(uuid, short_url, original_url, options, clicks_left)
+		SELECT $1::varchar, t.short_url, t.original_url, t.options::jsonb, NULLIF(t.clicks_left, '')::integer
+		FROM unnest($2::text[], $3::text[], $4::text[], $5::text[]) AS t(short_url, original_url, options, clicks_left)
+		ON CONFLICT DO NOTHING
+		RETURNING short_url, original_url`,
+	stmtGetExisting: `SELECT short_url, original_url FROM urls WHERE original_url = ANY($1)`,
+	stmtSetBlocked:  `UPDATE urls SET blocked = $2 WHERE short_url = ANY($1)`,
+	stmtCountURLS:   `SELECT count(*) FROM urls WHERE uuid = $1 AND NOT delflag`,
+	stmtLockUser:    `SELECT pg_advisory_xact_lock(hashtext($1))`,
+	stmtGetQuota:    `SELECT max_links, max_batch_size FROM quotas WHERE uuid = $1`,
+	stmtSetQuota: `INSERT INTO quotas(uuid, max_links, max_batch_size) VALUES($1, $2, $3)
+		ON CONFLICT (uuid) DO UPDATE SET max_links = EXCLUDED.max_links, max_batch_size = EXCLUDED.max_batch_size`,
+	stmtDeleteQuota: `DELETE FROM quotas WHERE uuid = $1`,
+	// unset fields are omitted from the JSON, so || keeps their stored values;
+	// cleared options and the tags replaced by an empty list are removed
+	// first by the keys in $6
+	stmtUpdateURL: `UPDATE urls SET options = (options - $6::text[]) || $3::jsonb, password_hash = COALESCE($4, password_hash),
+			clicks_left = CASE WHEN 'max_clicks' = ANY($6) THEN NULL ELSE COALESCE($5::integer, clicks_left) END
+		WHERE uuid = $1 AND short_url = $2 AND NOT delflag
+		RETURNING original_url, blocked, options, password_hash`,
+	// the condition makes concurrent redirects take distinct clicks
+	stmtUseClick:     `UPDATE urls SET clicks_left = clicks_left - 1 WHERE short_url = $1 AND clicks_left > 0`,
+	stmtInsertClicks: `INSERT INTO clicks(short_url, variant, country, clicked_at) SELECT * FROM unnest($1::text[], $2::text[], $3::text[], $4::timestamptz[])`,
+	stmtOwnsURL:      `SELECT EXISTS(SELECT 1 FROM urls WHERE uuid = $1 AND short_url = $2)`,
+	stmtClickStats:   `SELECT variant, country, count(*) FROM clicks WHERE short_url = $1 GROUP BY variant, country`,
+	stmtGetUTM:       `SELECT template FROM utm_templates WHERE uuid = $1`,
+	stmtSetUTM: `INSERT INTO utm_templates(uuid, template) VALUES($1, $2)
+		ON CONFLICT (uuid) DO UPDATE SET template = EXCLUDED.template`,
+	stmtDeleteUTM: `DELETE FROM utm_templates WHERE uuid = $1`,
+	// the window count is taken before LIMIT, newer links win ties
+	stmtSearchURLS: `SELECT short_url, original_url, options, COALESCE(collection_id, ''), count(*) OVER()
+		FROM urls, to_tsquery('simple', $2) AS query
+		WHERE uuid = $1 AND NOT delflag AND search @@ query
+		ORDER BY ts_rank(search, query) DESC, id DESC
+		LIMIT $3 OFFSET $4`,
+	stmtInsertCollection: `INSERT INTO collections(id, uuid, name) VALUES($1, $2, $3)`,
+	stmtGetCollections: `SELECT c.id, c.name, count(u.id) FROM collections c
+		LEFT JOIN urls u ON u.collection_id = c.id AND NOT u.delflag
+		WHERE c.uuid = $1 GROUP BY c.id ORDER BY c.name`,
+	stmtRenameCollection: `UPDATE collections SET name = $3 WHERE uuid = $1 AND id = $2`,
+	stmtDeleteCollection: `DELETE FROM collections WHERE uuid = $1 AND id = $2`,
+	stmtOwnsCollection:   `SELECT EXISTS(SELECT 1 FROM collections WHERE uuid = $1 AND id = $2)`,
+	stmtMoveURLS:         `UPDATE urls SET collection_id = NULLIF($3, '') WHERE uuid = $1 AND short_url = ANY($2)`,
+	stmtCollectionURLS:   `SELECT short_url FROM urls WHERE uuid = $1 AND collection_id = $2 AND NOT delflag`,
 }
 
 type dbStorage struct {
-	db *sql.DB
+	pool         *pgxpool.Pool
+	queryTimeout time.Duration
+}
+
+func NewDBStorage(pool *pgxpool.Pool, queryTimeout time.Duration) Repository {
+	return &dbStorage{
+		pool:         pool,
+		queryTimeout: queryTimeout,
+	}
+}
+
+// NewPool opens a connection pool and prepares the repository statements on
+// every new connection. InitDB has to be called before, since statements
+// can only be prepared against existing tables.
+func NewPool(ctx context.Context, dsn string, maxConns int, minConns int) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxConns > 0 {
+		cfg.MaxConns = int32(maxConns)
+	}
+	if minConns > 0 {
+		cfg.MinConns = int32(minConns)
+	}
+	cfg.AfterConnect = prepareStatements
+
+	return pgxpool.NewWithConfig(ctx, cfg)
 }
 
-func NewDBStorage(db *sql.DB) Repository {
-	return &dbStorage{db: db}
+func prepareStatements(ctx context.Context, conn *pgx.Conn) error {
+	for name, sql := range preparedStatements {
+		if _, err := conn.Prepare(ctx, name, sql); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func InitDB(ctx context.Context, db *sql.DB) error {
-	ctrl, cancel := context.WithTimeout(ctx, time.Millisecond*500)
+// InitDB migrates the schema. Unlike connecting, the migrations may take
+// long on a large table, they get their own timeout.
+func InitDB(ctx context.Context, dsn string, timeout time.Duration) error {
+	connCtx, cancelConn := context.WithTimeout(ctx, time.Millisecond*500)
+	defer cancelConn()
+
+	conn, err := pgx.Connect(connCtx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	ctrl, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	tx, err := db.BeginTx(ctx, nil)
+	tx, err := conn.Begin(ctrl)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	defer tx.Rollback(ctx)
 
-	_, err = db.ExecContext(ctrl, "CREATE TABLE IF NOT EXISTS urls(id serial primary key, delflag boolean DEFAULT false, uuid varchar(36), short_url varchar(20), original_url text)")
+	_, err = tx.Exec(ctrl, "CREATE TABLE IF NOT EXISTS urls(id serial primary key, delflag boolean DEFAULT false, uuid varchar(36), short_url varchar(20), original_url text)")
 	if err != nil {
 		return err
 	}
 
-	_, err = db.ExecContext(ctrl, "CREATE UNIQUE INDEX IF NOT EXISTS original_url_unique ON urls(original_url)")
+	_, err = tx.Exec(ctrl, "ALTER TABLE urls ADD COLUMN IF NOT EXISTS blocked boolean NOT NULL DEFAULT false")
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit()
-}
+	_, err = tx.Exec(ctrl, "ALTER TABLE urls ADD COLUMN IF NOT EXISTS options jsonb NOT NULL DEFAULT '{}'")
+	if err != nil {
+		return err
+	}
 
-func (s *dbStorage) DeleteURLS(ctx context.Context, userID string, shortLinks []string, logger *zap.SugaredLogger) {
+	_, err = tx.Exec(ctrl, "ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash text NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	_, err = tx.Exec(ctrl, "ALTER TABLE urls ADD COLUMN IF NOT EXISTS clicks_left integer")
 	if err != nil {
-		logger.Errorw("failed to delete urls", "error", err)
-		return
+		return err
+	}
+
+	// existing links keep a NULL creation date rather than the migration time
+	_, err = tx.Exec(ctrl, "ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at timestamptz")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "ALTER TABLE urls ALTER COLUMN created_at SET DEFAULT now()")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "CREATE INDEX IF NOT EXISTS urls_tags_idx ON urls USING gin ((options->'tags'))")
+	if err != nil {
+		return err
+	}
+
+	// the weights rank title and short link matches over tags over the URL,
+	// which is split into words as the default parser keeps URLs whole
+	_, err = tx.Exec(ctrl, `ALTER TABLE urls ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', COALESCE(short_url, '')), 'A') ||
+		setweight(to_tsvector('simple', COALESCE(options->>'title', '')), 'A') ||
+		setweight(jsonb_to_tsvector('simple', COALESCE(options->'tags', '[]'), '["string"]'), 'B') ||
+		setweight(to_tsvector('simple', regexp_replace(COALESCE(original_url, ''), '[^[:alnum:]]+', ' ', 'g')), 'C')
+	) STORED`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "CREATE INDEX IF NOT EXISTS urls_search_idx ON urls USING gin (search)")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "CREATE TABLE IF NOT EXISTS collections(id varchar(20) primary key, uuid varchar(36) NOT NULL, name text NOT NULL, UNIQUE (uuid, name))")
+	if err != nil {
+		return err
+	}
+
+	// deleting a collection keeps its links
+	_, err = tx.Exec(ctrl, "ALTER TABLE urls ADD COLUMN IF NOT EXISTS collection_id varchar(20) REFERENCES collections(id) ON DELETE SET NULL")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "CREATE INDEX IF NOT EXISTS urls_collection_idx ON urls(collection_id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "CREATE TABLE IF NOT EXISTS quotas(uuid varchar(36) primary key, max_links integer NOT NULL, max_batch_size integer NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "CREATE TABLE IF NOT EXISTS utm_templates(uuid varchar(36) primary key, template jsonb NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "CREATE TABLE IF NOT EXISTS clicks(id bigserial primary key, short_url varchar(20) NOT NULL, variant text NOT NULL DEFAULT '', clicked_at timestamptz NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "ALTER TABLE clicks ADD COLUMN IF NOT EXISTS country varchar(2) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "CREATE INDEX IF NOT EXISTS clicks_short_url_idx ON clicks(short_url)")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "CREATE UNIQUE INDEX IF NOT EXISTS original_url_unique ON urls(original_url)")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "DROP INDEX IF EXISTS short_url_idx")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctrl, "CREATE UNIQUE INDEX IF NOT EXISTS short_url_unique ON urls(short_url)")
+	if err != nil {
+		return err
 	}
-	defer tx.Rollback()
 
-	_, err = s.db.ExecContext(ctx, `UPDATE urls SET delflag = true WHERE uuid = $1 AND short_url = ANY($2)`, userID, shortLinks)
+	return tx.Commit(ctrl)
+}
+
+func (s *dbStorage) DeleteURLS(ctx context.Context, userID string, shortLinks []string, logger *zap.SugaredLogger) {
+
+	_, err := s.pool.Exec(ctx, stmtDeleteURLS, userID, shortLinks)
 	if err != nil {
 		logger.Errorw("failed to delete urls", "error", err)
-		return
 	}
-	tx.Commit()
 }
 
-func (s *dbStorage) GetUsersURLS(ctx context.Context, userID string, baseAddr string) ([]models.UsersURLS, error) {
+func (s *dbStorage) GetUsersURLS(ctx context.Context, userID string, baseAddr string, filter models.URLFilter) ([]models.UsersURLS, error) {
+
+	// a nil slice would be encoded as null, which contains nothing
+	tags := filter.Tags
+	if tags == nil {
+		tags = []string{}
+	}
 
-	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
 	defer cancel()
 
-	rows, err := s.db.QueryContext(ctrl, `SELECT short_url, original_url FROM urls WHERE uuid = $1`, userID)
+	rows, err := s.pool.Query(ctrl, stmtGetUsersURLS, userID, tags, filter.Collection)
 	if err != nil {
 		return nil, err
 	}
@@ -85,101 +366,629 @@ func (s *dbStorage) GetUsersURLS(ctx context.Context, userID string, baseAddr st
 
 	for rows.Next() {
 		var u models.UsersURLS
-		if err := rows.Scan(&u.ShortURL, &u.OriginalURL); err != nil {
-			log.Fatal(err)
+		var opts models.LinkOptions
+		if err := rows.Scan(&u.ShortURL, &u.OriginalURL, &opts, &u.Collection); err != nil {
+			return nil, err
 		}
+		u.LinkMeta = opts.LinkMeta
 		u.ShortURL = baseAddr + "/" + u.ShortURL
 		urls = append(urls, u)
 	}
-	if err := rows.Err(); err != nil {
-		log.Fatal(err)
+
+	return urls, rows.Err()
+}
+
+func (s *dbStorage) SearchURLS(ctx context.Context, userID string, baseAddr string, query string, limit int, offset int) (models.URLSearchResult, error) {
+	result := models.URLSearchResult{Results: []models.UsersURLS{}}
+
+	// every word has to match the start of a term
+	terms := utils.SearchTerms(query)
+	if len(terms) == 0 {
+		return result, nil
 	}
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
 
-	return urls, err
+	rows, err := s.pool.Query(ctrl, stmtSearchURLS, userID, strings.Join(terms, " & "), limit, offset)
+	if err != nil {
+		return models.URLSearchResult{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.UsersURLS
+		var opts models.LinkOptions
+		if err := rows.Scan(&u.ShortURL, &u.OriginalURL, &opts, &u.Collection, &result.Total); err != nil {
+			return models.URLSearchResult{}, err
+		}
+		u.ShortURL = baseAddr + "/" + u.ShortURL
+		u.LinkMeta = opts.LinkMeta
+		result.Results = append(result.Results, u)
+	}
+
+	return result, rows.Err()
 }
 
-func (s *dbStorage) ShortenURL(ctx context.Context, fullLink string) (string, error) {
+func (s *dbStorage) ShortenURL(ctx context.Context, fullLink string, opts models.LinkOptions, maxLinks int) (string, error) {
 
 	urlID := utils.Base62Encode(rand.Uint64())
 	uid := ctx.Value(models.CtxKey("userID"))
 
-	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
 	defer cancel()
-	_, err := s.db.ExecContext(ctrl, `INSERT INTO urls(uuid, short_url, original_url) VALUES($1, $2, $3)`, uid, urlID, fullLink)
+
+	tx, err := s.pool.Begin(ctrl)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctrl)
+
+	remaining, err := lockQuota(ctrl, tx, uid, maxLinks)
 	if err != nil {
-		if err, ok := err.(*pgconn.PgError); ok && err.Code == pgerrcode.UniqueViolation {
+		return "", err
+	}
+	if remaining == 0 {
+		return "", ErrQuotaExceeded
+	}
+
+	for attempt := 1; ; attempt++ {
+		// a failed insert aborts the transaction, retries need a savepoint
+		sp, err := tx.Begin(ctrl)
+		if err != nil {
+			return "", err
+		}
+		_, err = sp.Exec(ctrl, stmtInsertURL, uid, urlID, fullLink, opts, passwordHash(opts), opts.MaxClicks)
+		if err == nil {
+			if err = sp.Commit(ctrl); err != nil {
+				return "", err
+			}
+			break
+		}
+		if rbErr := sp.Rollback(ctrl); rbErr != nil {
+			return "", rbErr
+		}
+
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != pgerrcode.UniqueViolation {
+			return "", err
+		}
+		switch pgErr.ConstraintName {
+		case "original_url_unique":
 			var shortURL string
-			s.db.QueryRowContext(ctrl, `SELECT short_url FROM urls WHERE original_url = $1`, fullLink).Scan(&shortURL)
-			return shortURL, err
+			if err := tx.QueryRow(ctrl, stmtGetShortURL, fullLink).Scan(&shortURL); err != nil {
+				return "", err
+			}
+			return shortURL, pgErr
+		case "short_url_unique":
+			if attempt == maxIDAttempts {
+				return "", ErrAliasTaken
+			}
+			urlID = utils.Base62Encode(rand.Uint64())
+		default:
+			return "", err
 		}
+	}
+	if err = tx.Commit(ctrl); err != nil {
 		return "", err
 	}
 
 	return urlID, nil
 }
 
-func (s *dbStorage) GetFullURL(ctx context.Context, shortLink string) (string, error) {
+func (s *dbStorage) GetFullURL(ctx context.Context, shortLink string) (models.URL, error) {
 
-	var originalURL string
+	link := models.URL{ShortURL: shortLink}
 	var isDeleted bool
+	var hash string
+	var createdAt *time.Time
 
-	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
 	defer cancel()
 
-	//check if link is deleted
-	err := s.db.QueryRowContext(ctrl, `SELECT delflag FROM urls WHERE short_url = $1`, shortLink).Scan(&isDeleted)
+	err := s.pool.QueryRow(ctrl, stmtGetFullURL, shortLink).Scan(&link.OriginalURL, &isDeleted, &link.UserID, &link.Blocked, &link.Options, &hash, &createdAt)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", errors.New("link does not exist")
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.URL{}, ErrLinkNotFound
 		}
-		return "", err
+		return models.URL{}, err
 	}
 	if isDeleted {
-		return "", errors.New("link is deleted")
+		return models.URL{}, ErrLinkDeleted
+	}
+	if hash != "" {
+		link.Options.PasswordHash = &hash
+	}
+	if createdAt != nil {
+		link.CreatedAt = *createdAt
 	}
-	err = s.db.QueryRowContext(ctrl, `SELECT original_url FROM urls WHERE short_url = $1`, shortLink).Scan(&originalURL)
+
+	return link, nil
+}
+
+func (s *dbStorage) SetBlocked(ctx context.Context, shortLinks []string, blocked bool) error {
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	_, err := s.pool.Exec(ctrl, stmtSetBlocked, shortLinks, blocked)
+	return err
+}
+
+func (s *dbStorage) UpdateURL(ctx context.Context, userID string, shortLink string, opts models.LinkOptions) (models.URL, error) {
+
+	link := models.URL{ShortURL: shortLink, UserID: userID}
+	var hash string
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	// never nil, options - NULL would be NULL
+	removed := append([]string{}, opts.Clear...)
+	if opts.Tags != nil {
+		removed = append(removed, "tags")
+	}
+
+	err := s.pool.QueryRow(ctrl, stmtUpdateURL, userID, shortLink, opts, opts.PasswordHash, opts.MaxClicks, removed).Scan(&link.OriginalURL, &link.Blocked, &link.Options, &hash)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", errors.New("link does not exist")
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.URL{}, ErrLinkNotFound
 		}
-		return "", err
+		return models.URL{}, err
+	}
+	if hash != "" {
+		link.Options.PasswordHash = &hash
 	}
 
-	return originalURL, nil
+	return link, nil
 }
 
-func (s *dbStorage) ShortenURLBatch(ctx context.Context, batch []models.URLBatchRequest, baseAddr string) ([]models.URLRBatchResponse, error) {
+func (s *dbStorage) UseClick(ctx context.Context, shortLink string) error {
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	tag, err := s.pool.Exec(ctrl, stmtUseClick, shortLink)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrLinkExhausted
+	}
+	return nil
+}
+
+func (s *dbStorage) RecordClicks(ctx context.Context, clicks []models.Click) error {
+
+	shortURLs := make([]string, len(clicks))
+	variants := make([]string, len(clicks))
+	countries := make([]string, len(clicks))
+	times := make([]time.Time, len(clicks))
+	for i, click := range clicks {
+		shortURLs[i] = click.ShortURL
+		variants[i] = click.Variant
+		countries[i] = click.Country
+		times[i] = click.Time
+	}
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	_, err := s.pool.Exec(ctrl, stmtInsertClicks, shortURLs, variants, countries, times)
+	return err
+}
+
+func (s *dbStorage) GetClickStats(ctx context.Context, userID string, shortLink string) (models.ClickStats, error) {
+
+	var stats models.ClickStats
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var owns bool
+	if err := s.pool.QueryRow(ctrl, stmtOwnsURL, userID, shortLink).Scan(&owns); err != nil {
+		return stats, err
+	}
+	if !owns {
+		return stats, ErrLinkNotFound
+	}
+
+	rows, err := s.pool.Query(ctrl, stmtClickStats, shortLink)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variant, country string
+		var count int
+		if err := rows.Scan(&variant, &country, &count); err != nil {
+			return stats, err
+		}
+		stats.Total += count
+		if variant != "" {
+			if stats.Variants == nil {
+				stats.Variants = make(map[string]int)
+			}
+			stats.Variants[variant] += count
+		}
+		if country != "" {
+			if stats.Countries == nil {
+				stats.Countries = make(map[string]int)
+			}
+			stats.Countries[country] += count
+		}
+	}
+
+	return stats, rows.Err()
+}
+
+func (s *dbStorage) CountUsersURLS(ctx context.Context, userID string) (int, error) {
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var count int
+	err := s.pool.QueryRow(ctrl, stmtCountURLS, userID).Scan(&count)
+	return count, err
+}
+
+func (s *dbStorage) GetUserQuota(ctx context.Context, userID string) (*models.Quota, error) {
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var q models.Quota
+	err := s.pool.QueryRow(ctrl, stmtGetQuota, userID).Scan(&q.MaxLinks, &q.MaxBatchSize)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &q, nil
+}
+
+func (s *dbStorage) SetUserQuota(ctx context.Context, userID string, quota *models.Quota) error {
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var err error
+	if quota == nil {
+		_, err = s.pool.Exec(ctrl, stmtDeleteQuota, userID)
+	} else {
+		_, err = s.pool.Exec(ctrl, stmtSetQuota, userID, quota.MaxLinks, quota.MaxBatchSize)
+	}
+	return err
+}
+
+func (s *dbStorage) GetUserUTM(ctx context.Context, userID string) (*models.UTMTemplate, error) {
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var utm models.UTMTemplate
+	err := s.pool.QueryRow(ctrl, stmtGetUTM, userID).Scan(&utm)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &utm, nil
+}
+
+func (s *dbStorage) SetUserUTM(ctx context.Context, userID string, utm *models.UTMTemplate) error {
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var err error
+	if utm == nil {
+		_, err = s.pool.Exec(ctrl, stmtDeleteUTM, userID)
+	} else {
+		_, err = s.pool.Exec(ctrl, stmtSetUTM, userID, utm)
+	}
+	return err
+}
+
+func (s *dbStorage) CreateCollection(ctx context.Context, userID string, name string) (models.Collection, error) {
+
+	collection := models.Collection{ID: utils.Base62Encode(rand.Uint64()), Name: name, UserID: userID}
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	_, err := s.pool.Exec(ctrl, stmtInsertCollection, collection.ID, userID, name)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return models.Collection{}, ErrCollectionExists
+		}
+		return models.Collection{}, err
+	}
+	return collection, nil
+}
+
+func (s *dbStorage) GetCollections(ctx context.Context, userID string) ([]models.Collection, error) {
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctrl, stmtGetCollections, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []models.Collection{}
+	for rows.Next() {
+		c := models.Collection{UserID: userID}
+		if err := rows.Scan(&c.ID, &c.Name, &c.Links); err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+
+	return collections, rows.Err()
+}
+
+func (s *dbStorage) RenameCollection(ctx context.Context, userID string, collectionID string, name string) error {
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	tag, err := s.pool.Exec(ctrl, stmtRenameCollection, userID, collectionID, name)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrCollectionExists
+		}
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrCollectionNotFound
+	}
+	return nil
+}
+
+func (s *dbStorage) DeleteCollection(ctx context.Context, userID string, collectionID string) error {
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	tag, err := s.pool.Exec(ctrl, stmtDeleteCollection, userID, collectionID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrCollectionNotFound
+	}
+	return nil
+}
+
+func (s *dbStorage) MoveURLS(ctx context.Context, userID string, shortLinks []string, collectionID string) error {
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	if collectionID != "" {
+		if err := s.ownsCollection(ctrl, userID, collectionID); err != nil {
+			return err
+		}
+	}
+	_, err := s.pool.Exec(ctrl, stmtMoveURLS, userID, shortLinks, collectionID)
+	return err
+}
+
+func (s *dbStorage) CollectionURLS(ctx context.Context, userID string, collectionID string) ([]string, error) {
+
+	ctrl, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	if err := s.ownsCollection(ctrl, userID, collectionID); err != nil {
+		return nil, err
+	}
+	rows, err := s.pool.Query(ctrl, stmtCollectionURLS, userID, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// ownsCollection returns ErrCollectionNotFound unless userID has the
+// collection collectionID.
+func (s *dbStorage) ownsCollection(ctx context.Context, userID string, collectionID string) error {
+	var owns bool
+	if err := s.pool.QueryRow(ctx, stmtOwnsCollection, userID, collectionID).Scan(&owns); err != nil {
+		return err
+	}
+	if !owns {
+		return ErrCollectionNotFound
+	}
+	return nil
+}
+
+func (s *dbStorage) ShortenURLBatch(ctx context.Context, batch []models.URLBatchRequest, baseAddr string, maxLinks int) ([]models.URLRBatchResponse, error) {
 
 	if len(batch) == 0 {
 		return nil, errors.New("empty batch")
 	}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	uid, _ := ctx.Value(models.CtxKey("userID")).(string)
+
+	// the same URL may appear several times in a batch, only its first entry
+	// is inserted
+	unique := make([]models.URLBatchRequest, 0, len(batch))
+	seen := make(map[string]struct{}, len(batch))
+	for _, req := range batch {
+		if _, ok := seen[req.OriginalURL]; ok {
+			continue
+		}
+		seen[req.OriginalURL] = struct{}{}
+		unique = append(unique, req)
+	}
+
+	tx, err := s.pool.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
+	defer tx.Rollback(ctx)
 
-	responses := make([]models.URLRBatchResponse, len(batch))
+	remaining, err := lockQuota(ctx, tx, uid, maxLinks)
+	if err != nil {
+		return nil, err
+	}
 
-	for i, req := range batch {
-		urlID := utils.Base62Encode(rand.Uint64())
-		uid := ctx.Value(models.CtxKey("userID"))
-		_, err = tx.ExecContext(ctx, `INSERT INTO urls(uuid, short_url, original_url) VALUES($1, $2, $3)`, uid, urlID, req.OriginalURL)
+	stored := make(map[string]models.URLRBatchResponse, len(unique))
+
+	// chunks never hold more entries than links may still be created
+	start := 0
+	for start < len(unique) && remaining != 0 {
+		end := start + batchChunkSize
+		if remaining > 0 && remaining < batchChunkSize {
+			end = start + remaining
+		}
+		if end > len(unique) {
+			end = len(unique)
+		}
+		chunk := unique[start:end]
+		start = end
+
+		ids := make([]string, len(chunk))
+		originals := make([]string, len(chunk))
+		options := make([]string, len(chunk))
+		clicks := make([]string, len(chunk))
+		for i, req := range chunk {
+			ids[i] = req.Alias
+			if ids[i] == "" {
+				ids[i] = utils.Base62Encode(rand.Uint64())
+			}
+			originals[i] = req.OriginalURL
+			opts, err := json.Marshal(req.LinkOptions)
+			if err != nil {
+				return nil, err
+			}
+			options[i] = string(opts)
+			if req.MaxClicks != nil {
+				clicks[i] = strconv.Itoa(*req.MaxClicks)
+			}
+		}
+
+		before := len(stored)
+		err = collectBatchRows(ctx, tx, stored, baseAddr, models.BatchStatusCreated, stmtInsertBatch, uid, ids, originals, options, clicks)
 		if err != nil {
 			return nil, err
 		}
+		if remaining > 0 {
+			remaining -= len(stored) - before
+		}
 
-		responses[i] = models.URLRBatchResponse{
-			CorrelationID: req.CorrelationID,
-			ShortURL:      baseAddr + "/" + urlID,
+		missing := make([]string, 0, len(chunk))
+		for _, originalURL := range originals {
+			if _, ok := stored[originalURL]; !ok {
+				missing = append(missing, originalURL)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		err = collectBatchRows(ctx, tx, stored, baseAddr, models.BatchStatusExisting, stmtGetExisting, missing)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	if err = tx.Commit(); err != nil {
+	// entries beyond the quota may still refer to existing links
+	overQuota := make(map[string]struct{}, len(unique)-start)
+	for ; start < len(unique); start += batchChunkSize {
+		end := start + batchChunkSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+		originals := make([]string, 0, end-start)
+		for _, req := range unique[start:end] {
+			originals = append(originals, req.OriginalURL)
+			overQuota[req.OriginalURL] = struct{}{}
+		}
+		err = collectBatchRows(ctx, tx, stored, baseAddr, models.BatchStatusExisting, stmtGetExisting, originals)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
 		return nil, err
 	}
 
+	responses := make([]models.URLRBatchResponse, len(batch))
+	for i, req := range batch {
+		resp, ok := stored[req.OriginalURL]
+		if _, over := overQuota[req.OriginalURL]; !ok && over {
+			resp = models.URLRBatchResponse{Status: models.BatchStatusInvalid, Error: ErrQuotaExceeded.Error()}
+		} else if !ok {
+			// neither inserted nor stored before: the short link is taken
+			resp = models.URLRBatchResponse{Status: models.BatchStatusInvalid, Error: "short link is already taken"}
+		}
+		resp.CorrelationID = req.CorrelationID
+		responses[i] = resp
+		if ok {
+			// repeated entries of the batch point to the link of the first one
+			stored[req.OriginalURL] = models.URLRBatchResponse{ShortURL: resp.ShortURL, Status: models.BatchStatusExisting}
+		}
+	}
+
 	return responses, nil
 
 }
+
+func passwordHash(opts models.LinkOptions) string {
+	if opts.PasswordHash == nil {
+		return ""
+	}
+	return *opts.PasswordHash
+}
+
+// lockQuota serializes the link creation of userID until tx ends and returns
+// how many links the user may still create, -1 if maxLinks is 0.
+func lockQuota(ctx context.Context, tx pgx.Tx, userID any, maxLinks int) (int, error) {
+	if maxLinks <= 0 {
+		return -1, nil
+	}
+	if _, err := tx.Exec(ctx, stmtLockUser, userID); err != nil {
+		return 0, err
+	}
+	var count int
+	if err := tx.QueryRow(ctx, stmtCountURLS, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count >= maxLinks {
+		return 0, nil
+	}
+	return maxLinks - count, nil
+}
+
+// collectBatchRows runs a query returning (short_url, original_url) rows and
+// records them in stored with the given status.
+func collectBatchRows(ctx context.Context, tx pgx.Tx, stored map[string]models.URLRBatchResponse, baseAddr string, status string, sql string, args ...any) error {
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shortURL, originalURL string
+		if err := rows.Scan(&shortURL, &originalURL); err != nil {
+			return err
+		}
+		stored[originalURL] = models.URLRBatchResponse{ShortURL: baseAddr + "/" + shortURL, Status: status}
+	}
+
+	return rows.Err()
+}
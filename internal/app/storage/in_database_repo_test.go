@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"github.com/FeelDat/urlshort/internal/utils"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Database tests and benchmarks run against DATABASE_DSN and are skipped
+// without it:
+//
+//	DATABASE_DSN="host=localhost user=postgres dbname=yandex sslmode=disable" go test -run DB -bench DB ./internal/app/storage/
+func newDBStorage(tb testing.TB) (Repository, string) {
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		tb.Skip("DATABASE_DSN is not set")
+	}
+
+	ctx := context.Background()
+	require.NoError(tb, InitDB(ctx, dsn, time.Minute))
+
+	pool, err := NewPool(ctx, dsn, 20, 2)
+	require.NoError(tb, err)
+	tb.Cleanup(pool.Close)
+
+	return NewDBStorage(pool, 2*time.Second), dsn
+}
+
+// legacyGetFullURL is the database/sql implementation dbStorage.GetFullURL
+// replaced: two sequential queries with ad-hoc SQL per redirect.
+func legacyGetFullURL(ctx context.Context, db *sql.DB, shortLink string) (string, error) {
+	var originalURL string
+	var isDeleted bool
+
+	ctrl, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+
+	err := db.QueryRowContext(ctrl, `SELECT delflag FROM urls WHERE short_url = $1`, shortLink).Scan(&isDeleted)
+	if err != nil {
+		return "", err
+	}
+	if isDeleted {
+		return "", ErrLinkDeleted
+	}
+	err = db.QueryRowContext(ctrl, `SELECT original_url FROM urls WHERE short_url = $1`, shortLink).Scan(&originalURL)
+	return originalURL, err
+}
+
+func TestDBUpdateURL(t *testing.T) {
+	repo, _ := newDBStorage(t)
+
+	userID := "testUserID"
+	ctx := context.WithValue(context.Background(), models.CtxKey("userID"), userID)
+	title := "Docs"
+	shortURL, err := repo.ShortenURL(ctx, "https://practicum.yandex.ru/tags/"+time.Now().String(), models.LinkOptions{
+		LinkMeta: models.LinkMeta{Title: &title, Tags: []string{"go", "docs"}},
+	}, 0)
+	require.NoError(t, err)
+
+	// fields left out of an update keep their stored values
+	notes := "read later"
+	link, err := repo.UpdateURL(ctx, userID, shortURL, models.LinkOptions{LinkMeta: models.LinkMeta{Notes: &notes}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go", "docs"}, link.Options.Tags)
+	assert.Equal(t, &notes, link.Options.Notes)
+
+	// an empty list is omitted from the JSON as well, but removes the tags
+	link, err = repo.UpdateURL(ctx, userID, shortURL, models.LinkOptions{LinkMeta: models.LinkMeta{Tags: []string{}}})
+	require.NoError(t, err)
+	assert.Empty(t, link.Options.Tags)
+	assert.Equal(t, &title, link.Options.Title)
+
+	stored, err := repo.GetFullURL(ctx, shortURL)
+	require.NoError(t, err)
+	assert.Empty(t, stored.Options.Tags)
+
+	// cleared options are removed
+	code, clicks := 301, 1
+	_, err = repo.UpdateURL(ctx, userID, shortURL, models.LinkOptions{RedirectCode: &code, MaxClicks: &clicks})
+	require.NoError(t, err)
+	link, err = repo.UpdateURL(ctx, userID, shortURL, models.LinkOptions{Clear: []string{"redirect_code", "max_clicks"}})
+	require.NoError(t, err)
+	assert.Nil(t, link.Options.RedirectCode)
+	assert.Nil(t, link.Options.MaxClicks)
+	assert.Equal(t, &title, link.Options.Title)
+}
+
+func TestDBShortenURLConflicts(t *testing.T) {
+	repo, _ := newDBStorage(t)
+
+	userID := "test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	ctx := context.WithValue(context.Background(), models.CtxKey("userID"), userID)
+	fullURL := "https://practicum.yandex.ru/" + userID
+
+	shortURL, err := repo.ShortenURL(ctx, fullURL, models.LinkOptions{}, 0)
+	require.NoError(t, err)
+	existing, err := repo.ShortenURL(ctx, fullURL, models.LinkOptions{}, 0)
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, shortURL, existing)
+
+	// a generated ID already used by another URL is replaced
+	rand.Seed(1)
+	taken := utils.Base62Encode(rand.Uint64())
+	_, err = repo.(*dbStorage).pool.Exec(ctx, `INSERT INTO urls(uuid, short_url, original_url) VALUES($1, $2, $3) ON CONFLICT DO NOTHING`, userID, taken, fullURL+"/taken")
+	require.NoError(t, err)
+	rand.Seed(1)
+	shortURL, err = repo.ShortenURL(ctx, fullURL+"/new", models.LinkOptions{}, 0)
+	require.NoError(t, err)
+	assert.NotEqual(t, taken, shortURL)
+}
+
+func TestDBDeleteCollectionURLS(t *testing.T) {
+	repo, _ := newDBStorage(t)
+
+	// a fresh user, collection names are unique per user
+	userID := "test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	ctx := context.WithValue(context.Background(), models.CtxKey("userID"), userID)
+	collection, err := repo.CreateCollection(ctx, userID, "Posters")
+	require.NoError(t, err)
+
+	var shortLinks []string
+	for i := 0; i < 2; i++ {
+		shortURL, err := repo.ShortenURL(ctx, "https://practicum.yandex.ru/"+userID+"/"+strconv.Itoa(i), models.LinkOptions{}, 0)
+		require.NoError(t, err)
+		shortLinks = append(shortLinks, shortURL)
+	}
+	require.NoError(t, repo.MoveURLS(ctx, userID, shortLinks, collection.ID))
+
+	inCollection, err := repo.CollectionURLS(ctx, userID, collection.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, shortLinks, inCollection)
+	repo.DeleteURLS(ctx, userID, inCollection, zap.NewNop().Sugar())
+
+	urls, err := repo.GetUsersURLS(ctx, userID, "http://localhost:8080", models.URLFilter{Collection: collection.ID})
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+	urls, err = repo.GetUsersURLS(ctx, userID, "http://localhost:8080", models.URLFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	_, err = repo.GetFullURL(ctx, shortLinks[0])
+	assert.ErrorIs(t, err, ErrLinkDeleted)
+}
+
+func BenchmarkDBGetFullURL(b *testing.B) {
+	repo, dsn := newDBStorage(b)
+
+	ctx := context.WithValue(context.Background(), models.CtxKey("userID"), "benchUserID")
+	shortURL, err := repo.ShortenURL(ctx, "https://practicum.yandex.ru/bench/"+time.Now().String(), models.LinkOptions{}, 0)
+	require.NoError(b, err)
+
+	b.Run("database_sql", func(b *testing.B) {
+		db, err := sql.Open("pgx", dsn)
+		require.NoError(b, err)
+		defer db.Close()
+		db.SetMaxOpenConns(20)
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := legacyGetFullURL(ctx, db, shortURL); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+
+	b.Run("pgxpool", func(b *testing.B) {
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := repo.GetFullURL(ctx, shortURL); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+}
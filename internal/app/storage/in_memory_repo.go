@@ -9,6 +9,9 @@ import (
 	"go.uber.org/zap"
 	"math/rand"
 	"os"
+	"sort"
+	"sync"
+	"time"
 )
 
 type URLInfo struct {
@@ -18,9 +21,30 @@ type URLInfo struct {
 }
 
 type storage struct {
-	Links    map[string]string
-	UserURLs map[string][]models.UsersURLS
-	file     *os.File
+	mu    sync.RWMutex
+	Links map[string]*models.URL
+	// UserURLs holds the short links of every user in creation order.
+	UserURLs map[string][]string
+	// Originals maps an original URL to its short link, mirroring the unique
+	// index on urls.original_url of the database storage.
+	Originals map[string]string
+	// Quotas holds the quota overrides, they are not written to the file.
+	Quotas map[string]models.Quota
+	// UTMs holds the UTM templates of the users, they are not written to
+	// the file either.
+	UTMs map[string]models.UTMTemplate
+	// ClicksLeft counts down the redirects of links with MaxClicks set.
+	ClicksLeft map[string]int
+	// Stats aggregates the clicks of every link instead of keeping them.
+	Stats map[string]*models.ClickStats
+	// Collections holds the collections of every user by ID.
+	Collections map[string]*models.Collection
+	// Deleted holds the soft deleted links. Like the flagged rows of the
+	// database they stay in Links, so that GetFullURL can report them, but
+	// are removed from UserURLs.
+	Deleted map[string]struct{}
+	search  *searchIndex
+	file    *os.File
 }
 
 func NewInMemStorage(filePath string) (Repository, error) {
@@ -35,58 +59,129 @@ func NewInMemStorage(filePath string) (Repository, error) {
 	}
 
 	return &storage{
-		Links:    make(map[string]string),
-		UserURLs: make(map[string][]models.UsersURLS),
-		file:     file,
+		Links:       make(map[string]*models.URL),
+		UserURLs:    make(map[string][]string),
+		Originals:   make(map[string]string),
+		Quotas:      make(map[string]models.Quota),
+		UTMs:        make(map[string]models.UTMTemplate),
+		ClicksLeft:  make(map[string]int),
+		Stats:       make(map[string]*models.ClickStats),
+		Collections: make(map[string]*models.Collection),
+		Deleted:     make(map[string]struct{}),
+		search:      newSearchIndex(),
+		file:        file,
 	}, err
 }
 
 func (s *storage) DeleteURLS(ctx context.Context, userID string, shortLinks []string, logger *zap.SugaredLogger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, shortLink := range shortLinks {
+		if link, ok := s.Links[shortLink]; ok && link.UserID == userID {
+			s.Deleted[shortLink] = struct{}{}
+			s.search.remove(shortLink)
+		}
+	}
+
+	// deleted links are no longer listed nor counted against the quota
+	kept := s.UserURLs[userID][:0]
+	for _, urlID := range s.UserURLs[userID] {
+		if _, ok := s.Deleted[urlID]; !ok {
+			kept = append(kept, urlID)
+		}
+	}
+	s.UserURLs[userID] = kept
 }
 
-func (s *storage) GetUsersURLS(ctx context.Context, userID string, baseAddr string) ([]models.UsersURLS, error) {
-	if urls, ok := s.UserURLs[userID]; ok {
-		return urls, nil
+func (s *storage) GetUsersURLS(ctx context.Context, userID string, baseAddr string, filter models.URLFilter) ([]models.UsersURLS, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var urls []models.UsersURLS
+	for _, urlID := range s.UserURLs[userID] {
+		link := s.Links[urlID]
+		if !link.Options.HasTags(filter.Tags) {
+			continue
+		}
+		if filter.Collection != "" && link.Collection != filter.Collection {
+			continue
+		}
+		urls = append(urls, models.UsersURLS{
+			ShortURL:    baseAddr + "/" + urlID,
+			OriginalURL: link.OriginalURL,
+			Collection:  link.Collection,
+			LinkMeta:    link.Options.LinkMeta,
+		})
 	}
 
-	return nil, errors.New("no URLs found for the given userID")
+	return urls, nil
 }
 
-func (s *storage) ShortenURL(ctx context.Context, fullLink string) (string, error) {
-	urlID := utils.Base62Encode(rand.Uint64())
-	uid := ctx.Value(models.CtxKey("userID"))
+func (s *storage) SearchURLS(ctx context.Context, userID string, baseAddr string, query string, limit int, offset int) (models.URLSearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	urlInfo := URLInfo{
-		UUID:        uid.(string),
-		ShortURL:    urlID,
-		OriginalURL: fullLink,
+	// newest links first among equally good matches
+	ids := s.UserURLs[userID]
+	candidates := make([]string, len(ids))
+	for i, urlID := range ids {
+		candidates[len(ids)-1-i] = urlID
 	}
 
-	data, err := json.Marshal(&urlInfo)
-	if err != nil {
-		return "", err
+	matches := s.search.search(utils.SearchTerms(query), candidates)
+	result := models.URLSearchResult{Total: len(matches), Results: []models.UsersURLS{}}
+	if offset >= len(matches) {
+		return result, nil
+	}
+	matches = matches[offset:]
+	if len(matches) > limit {
+		matches = matches[:limit]
 	}
+	for _, urlID := range matches {
+		link := s.Links[urlID]
+		result.Results = append(result.Results, models.UsersURLS{
+			ShortURL:    baseAddr + "/" + urlID,
+			OriginalURL: link.OriginalURL,
+			Collection:  link.Collection,
+			LinkMeta:    link.Options.LinkMeta,
+		})
+	}
+	return result, nil
+}
 
-	_, err = s.file.Write(data)
-	if err != nil {
+func (s *storage) ShortenURL(ctx context.Context, fullLink string, opts models.LinkOptions, maxLinks int) (string, error) {
+	urlID := utils.Base62Encode(rand.Uint64())
+	uid := ctx.Value(models.CtxKey("userID"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.remainingLinks(uid.(string), maxLinks) == 0 {
+		return "", ErrQuotaExceeded
+	}
+	if err := s.store(uid.(string), urlID, fullLink, opts); err != nil {
 		return "", err
 	}
-	s.Links[urlID] = fullLink
-
-	s.UserURLs[uid.(string)] = append(s.UserURLs[uid.(string)], models.UsersURLS{OriginalURL: fullLink, ShortURL: urlID})
 
 	return urlID, nil
 }
 
-func (s *storage) GetFullURL(ctx context.Context, shortLink string) (string, error) {
-	val, ok := s.Links[shortLink]
+func (s *storage) GetFullURL(ctx context.Context, shortLink string) (models.URL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	link, ok := s.Links[shortLink]
 	if !ok {
-		return "", errors.New("link does not exist")
+		return models.URL{}, ErrLinkNotFound
+	}
+	if _, ok := s.Deleted[shortLink]; ok {
+		return models.URL{}, ErrLinkDeleted
 	}
-	return val, nil
+	return *link, nil
 }
 
-func (s *storage) ShortenURLBatch(ctx context.Context, batch []models.URLBatchRequest, baseAddr string) ([]models.URLRBatchResponse, error) {
+func (s *storage) ShortenURLBatch(ctx context.Context, batch []models.URLBatchRequest, baseAddr string, maxLinks int) ([]models.URLRBatchResponse, error) {
 	if len(batch) == 0 {
 		return nil, errors.New("empty batch")
 	}
@@ -94,32 +189,358 @@ func (s *storage) ShortenURLBatch(ctx context.Context, batch []models.URLBatchRe
 	responses := make([]models.URLRBatchResponse, len(batch))
 	uid := ctx.Value(models.CtxKey("userID"))
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remaining := s.remainingLinks(uid.(string), maxLinks)
+
 	for i, req := range batch {
-		urlID := utils.Base62Encode(rand.Uint64())
-		urlInfo := URLInfo{
-			UUID:        uid.(string),
-			ShortURL:    urlID,
-			OriginalURL: req.OriginalURL,
+		if urlID, ok := s.Originals[req.OriginalURL]; ok {
+			responses[i] = models.URLRBatchResponse{
+				CorrelationID: req.CorrelationID,
+				ShortURL:      baseAddr + "/" + urlID,
+				Status:        models.BatchStatusExisting,
+			}
+			continue
 		}
 
-		data, err := json.Marshal(&urlInfo)
-		if err != nil {
-			return nil, err
+		urlID := req.Alias
+		if urlID == "" {
+			urlID = utils.Base62Encode(rand.Uint64())
 		}
-
-		_, err = s.file.Write(data)
-		if err != nil {
+		if _, ok := s.Links[urlID]; ok {
+			responses[i] = models.URLRBatchResponse{
+				CorrelationID: req.CorrelationID,
+				Status:        models.BatchStatusInvalid,
+				Error:         "short link is already taken",
+			}
+			continue
+		}
+		if remaining == 0 {
+			responses[i] = models.URLRBatchResponse{
+				CorrelationID: req.CorrelationID,
+				Status:        models.BatchStatusInvalid,
+				Error:         ErrQuotaExceeded.Error(),
+			}
+			continue
+		}
+		if err := s.store(uid.(string), urlID, req.OriginalURL, req.LinkOptions); err != nil {
 			return nil, err
 		}
-
-		s.Links[urlID] = req.OriginalURL
-		s.UserURLs[uid.(string)] = append(s.UserURLs[uid.(string)], models.UsersURLS{OriginalURL: req.OriginalURL, ShortURL: urlID})
+		remaining--
 
 		responses[i] = models.URLRBatchResponse{
 			CorrelationID: req.CorrelationID,
 			ShortURL:      baseAddr + "/" + urlID,
+			Status:        models.BatchStatusCreated,
 		}
 	}
 
 	return responses, nil
 }
+
+func (s *storage) SetBlocked(ctx context.Context, shortLinks []string, blocked bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, shortLink := range shortLinks {
+		if link, ok := s.Links[shortLink]; ok {
+			link.Blocked = blocked
+		}
+	}
+	return nil
+}
+
+func (s *storage) UpdateURL(ctx context.Context, userID string, shortLink string, opts models.LinkOptions) (models.URL, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.Links[shortLink]
+	if _, deleted := s.Deleted[shortLink]; !ok || deleted || link.UserID != userID {
+		return models.URL{}, ErrLinkNotFound
+	}
+	link.Options.Merge(opts)
+	s.search.add(link)
+	if opts.MaxClicks != nil {
+		s.ClicksLeft[shortLink] = *opts.MaxClicks
+	}
+	if link.Options.MaxClicks == nil {
+		delete(s.ClicksLeft, shortLink)
+	}
+	return *link, nil
+}
+
+func (s *storage) UseClick(ctx context.Context, shortLink string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	left, ok := s.ClicksLeft[shortLink]
+	if !ok || left <= 0 {
+		return ErrLinkExhausted
+	}
+	s.ClicksLeft[shortLink] = left - 1
+	return nil
+}
+
+func (s *storage) RecordClicks(ctx context.Context, clicks []models.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, click := range clicks {
+		stats, ok := s.Stats[click.ShortURL]
+		if !ok {
+			stats = &models.ClickStats{}
+			s.Stats[click.ShortURL] = stats
+		}
+		stats.Add(click)
+	}
+	return nil
+}
+
+func (s *storage) GetClickStats(ctx context.Context, userID string, shortLink string) (models.ClickStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	link, ok := s.Links[shortLink]
+	if !ok || link.UserID != userID {
+		return models.ClickStats{}, ErrLinkNotFound
+	}
+
+	stats, ok := s.Stats[shortLink]
+	if !ok {
+		return models.ClickStats{}, nil
+	}
+	res := models.ClickStats{Total: stats.Total}
+	if stats.Variants != nil {
+		res.Variants = make(map[string]int, len(stats.Variants))
+		for variant, count := range stats.Variants {
+			res.Variants[variant] = count
+		}
+	}
+	if stats.Countries != nil {
+		res.Countries = make(map[string]int, len(stats.Countries))
+		for country, count := range stats.Countries {
+			res.Countries[country] = count
+		}
+	}
+	return res, nil
+}
+
+func (s *storage) CountUsersURLS(ctx context.Context, userID string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.UserURLs[userID]), nil
+}
+
+func (s *storage) GetUserQuota(ctx context.Context, userID string) (*models.Quota, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	q, ok := s.Quotas[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &q, nil
+}
+
+func (s *storage) SetUserQuota(ctx context.Context, userID string, quota *models.Quota) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if quota == nil {
+		delete(s.Quotas, userID)
+	} else {
+		s.Quotas[userID] = *quota
+	}
+	return nil
+}
+
+func (s *storage) GetUserUTM(ctx context.Context, userID string) (*models.UTMTemplate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	utm, ok := s.UTMs[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &utm, nil
+}
+
+func (s *storage) SetUserUTM(ctx context.Context, userID string, utm *models.UTMTemplate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if utm == nil {
+		delete(s.UTMs, userID)
+	} else {
+		s.UTMs[userID] = *utm
+	}
+	return nil
+}
+
+func (s *storage) CreateCollection(ctx context.Context, userID string, name string) (models.Collection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.collectionNamed(userID, name) != nil {
+		return models.Collection{}, ErrCollectionExists
+	}
+	collection := &models.Collection{ID: utils.Base62Encode(rand.Uint64()), Name: name, UserID: userID}
+	s.Collections[collection.ID] = collection
+	return *collection, nil
+}
+
+func (s *storage) GetCollections(ctx context.Context, userID string) ([]models.Collection, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, urlID := range s.UserURLs[userID] {
+		if id := s.Links[urlID].Collection; id != "" {
+			counts[id]++
+		}
+	}
+
+	collections := []models.Collection{}
+	for _, c := range s.Collections {
+		if c.UserID == userID {
+			collection := *c
+			collection.Links = counts[c.ID]
+			collections = append(collections, collection)
+		}
+	}
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+	return collections, nil
+}
+
+func (s *storage) RenameCollection(ctx context.Context, userID string, collectionID string, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	collection, ok := s.Collections[collectionID]
+	if !ok || collection.UserID != userID {
+		return ErrCollectionNotFound
+	}
+	if other := s.collectionNamed(userID, name); other != nil && other != collection {
+		return ErrCollectionExists
+	}
+	collection.Name = name
+	return nil
+}
+
+func (s *storage) DeleteCollection(ctx context.Context, userID string, collectionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	collection, ok := s.Collections[collectionID]
+	if !ok || collection.UserID != userID {
+		return ErrCollectionNotFound
+	}
+	delete(s.Collections, collectionID)
+	for _, urlID := range s.UserURLs[userID] {
+		if link := s.Links[urlID]; link.Collection == collectionID {
+			link.Collection = ""
+		}
+	}
+	return nil
+}
+
+func (s *storage) MoveURLS(ctx context.Context, userID string, shortLinks []string, collectionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if collectionID != "" {
+		collection, ok := s.Collections[collectionID]
+		if !ok || collection.UserID != userID {
+			return ErrCollectionNotFound
+		}
+	}
+	for _, shortLink := range shortLinks {
+		if link, ok := s.Links[shortLink]; ok && link.UserID == userID {
+			link.Collection = collectionID
+		}
+	}
+	return nil
+}
+
+func (s *storage) CollectionURLS(ctx context.Context, userID string, collectionID string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	collection, ok := s.Collections[collectionID]
+	if !ok || collection.UserID != userID {
+		return nil, ErrCollectionNotFound
+	}
+	var shortLinks []string
+	for _, urlID := range s.UserURLs[userID] {
+		if s.Links[urlID].Collection == collectionID {
+			shortLinks = append(shortLinks, urlID)
+		}
+	}
+	return shortLinks, nil
+}
+
+// collectionNamed returns the collection of userID named name, or nil. The
+// caller must hold s.mu.
+func (s *storage) collectionNamed(userID string, name string) *models.Collection {
+	for _, c := range s.Collections {
+		if c.UserID == userID && c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// remainingLinks is the number of links userID may still create, -1 if
+// maxLinks is 0. The caller must hold s.mu.
+func (s *storage) remainingLinks(userID string, maxLinks int) int {
+	if maxLinks <= 0 {
+		return -1
+	}
+	if n := len(s.UserURLs[userID]); n < maxLinks {
+		return maxLinks - n
+	}
+	return 0
+}
+
+// store writes a new link to the file and the in-memory indexes. The caller
+// must hold s.mu.
+func (s *storage) store(userID string, urlID string, fullLink string, opts models.LinkOptions) error {
+	urlInfo := URLInfo{
+		UUID:        userID,
+		ShortURL:    urlID,
+		OriginalURL: fullLink,
+	}
+
+	data, err := json.Marshal(&urlInfo)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.file.Write(data)
+	if err != nil {
+		return err
+	}
+
+	link := &models.URL{
+		ShortURL:    urlID,
+		OriginalURL: fullLink,
+		UserID:      userID,
+		Options:     opts,
+		CreatedAt:   time.Now(),
+	}
+	s.Links[urlID] = link
+	s.search.add(link)
+	if _, ok := s.Originals[fullLink]; !ok {
+		s.Originals[fullLink] = urlID
+	}
+	s.UserURLs[userID] = append(s.UserURLs[userID], urlID)
+	if opts.MaxClicks != nil {
+		s.ClicksLeft[urlID] = *opts.MaxClicks
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"github.com/FeelDat/urlshort/internal/utils"
+	"sort"
+	"strings"
+)
+
+// Weights of the fields of a link in search results, mirroring the weights
+// of the search column of the database storage.
+const (
+	weightTitle = 4
+	weightTag   = 2
+	weightURL   = 1
+)
+
+// searchIndex is an inverted index from search terms to the short links
+// containing them, weighted by the best field the term appears in.
+type searchIndex struct {
+	postings map[string]map[string]int
+	// terms remembers the terms of every link, so it can be reindexed.
+	terms map[string][]string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string]int),
+		terms:    make(map[string][]string),
+	}
+}
+
+// add indexes link, replacing the terms it was indexed with before.
+func (idx *searchIndex) add(link *models.URL) {
+	idx.remove(link.ShortURL)
+
+	weights := make(map[string]int)
+	index := func(text string, weight int) {
+		for _, term := range utils.SearchTerms(text) {
+			if weight > weights[term] {
+				weights[term] = weight
+			}
+		}
+	}
+	index(link.ShortURL, weightTitle)
+	if link.Options.Title != nil {
+		index(*link.Options.Title, weightTitle)
+	}
+	for _, tag := range link.Options.Tags {
+		index(tag, weightTag)
+	}
+	index(link.OriginalURL, weightURL)
+
+	terms := make([]string, 0, len(weights))
+	for term, weight := range weights {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]int)
+		}
+		idx.postings[term][link.ShortURL] = weight
+		terms = append(terms, term)
+	}
+	idx.terms[link.ShortURL] = terms
+}
+
+func (idx *searchIndex) remove(shortURL string) {
+	for _, term := range idx.terms[shortURL] {
+		delete(idx.postings[term], shortURL)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.terms, shortURL)
+}
+
+// search scores the links containing every one of words, or a term they are
+// a prefix of, and returns them best first. Ties keep the order of
+// candidates.
+func (idx *searchIndex) search(words []string, candidates []string) []string {
+	if len(words) == 0 {
+		return nil
+	}
+
+	scores := make(map[string]int)
+	for i, word := range words {
+		matches := make(map[string]int)
+		for term, links := range idx.postings {
+			if !strings.HasPrefix(term, word) {
+				continue
+			}
+			for link, weight := range links {
+				if weight > matches[link] {
+					matches[link] = weight
+				}
+			}
+		}
+		for link, weight := range matches {
+			// links missing an earlier word are not candidates any more
+			if _, ok := scores[link]; ok || i == 0 {
+				scores[link] += weight
+			}
+		}
+		for link := range scores {
+			if _, ok := matches[link]; !ok {
+				delete(scores, link)
+			}
+		}
+	}
+
+	var results []string
+	for _, link := range candidates {
+		if _, ok := scores[link]; ok {
+			results = append(results, link)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return scores[results[i]] > scores[results[j]]
+	})
+	return results
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"github.com/FeelDat/urlshort/internal/app/models"
+	"github.com/FeelDat/urlshort/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSearchIndex(t *testing.T) {
+	title := "Spring sale"
+	idx := newSearchIndex()
+	idx.add(&models.URL{ShortURL: "a1", OriginalURL: "https://shop.example.com/spring-sale"})
+	idx.add(&models.URL{ShortURL: "b2", OriginalURL: "https://example.com/p/42", Options: models.LinkOptions{
+		LinkMeta: models.LinkMeta{Title: &title, Tags: []string{"posters"}},
+	}})
+	idx.add(&models.URL{ShortURL: "c3", OriginalURL: "https://example.com/about"})
+	candidates := []string{"c3", "b2", "a1"}
+
+	search := func(q string) []string {
+		return idx.search(utils.SearchTerms(q), candidates)
+	}
+
+	// the title outranks the URL, words match prefixes of terms
+	assert.Equal(t, []string{"b2", "a1"}, search("Spring"))
+	assert.Equal(t, []string{"b2", "a1"}, search("spr"))
+	assert.Equal(t, []string{"b2"}, search("spring poster"))
+	assert.Equal(t, []string{"c3"}, search("c3"))
+	assert.Equal(t, []string{"c3", "b2", "a1"}, search("example"))
+	assert.Empty(t, search("autumn"))
+
+	idx.add(&models.URL{ShortURL: "b2", OriginalURL: "https://example.com/p/42"})
+	assert.Equal(t, []string{"a1"}, search("spring"))
+	assert.Empty(t, search("posters"))
+}
@@ -0,0 +1,36 @@
+package custommiddleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+type AdminMiddleware struct {
+	token string
+}
+
+// NewAdminMiddleware guards admin routes with a static bearer token. With an
+// empty token the admin API is disabled.
+func NewAdminMiddleware(token string) *AdminMiddleware {
+	return &AdminMiddleware{
+		token: token,
+	}
+}
+
+func (m *AdminMiddleware) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if m.token == "" {
+			http.Error(w, "Admin API is disabled", http.StatusForbidden)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
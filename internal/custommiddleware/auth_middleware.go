@@ -1,7 +1,9 @@
 package custommiddleware
 
 import (
+	"context"
 	"fmt"
+	"github.com/FeelDat/urlshort/internal/utils"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"net/http"
@@ -9,6 +11,10 @@ import (
 	"time"
 )
 
+// newSessionKey marks the context of requests the auth middleware issued a new
+// token for.
+type newSessionKey struct{}
+
 type AuthMiddleware struct {
 	key string
 }
@@ -42,6 +48,7 @@ func (m *AuthMiddleware) AuthMiddleware(next http.Handler) http.Handler {
 				Expires:  time.Now().Add(24 * time.Hour),
 				HttpOnly: true,
 			})
+			r = r.WithContext(context.WithValue(r.Context(), newSessionKey{}, true))
 		}
 		next.ServeHTTP(w, r)
 	})
@@ -71,7 +78,7 @@ func (m *AuthMiddleware) createToken() (string, error) {
 	claims["userID"] = userID // Установите имя пользователя или идентификатор здесь
 	claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
 
-	m.key = "8PNHgjK2kPunGpzMgL0ZmMdJCRKy2EnL/Cg0GbnELLI="
+	m.key = utils.JWTSigningKey
 	tokenString, err := token.SignedString([]byte(m.key))
 
 	if err != nil {
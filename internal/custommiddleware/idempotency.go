@@ -0,0 +1,102 @@
+package custommiddleware
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// IdempotentResponse is the response recorded for an idempotency key.
+type IdempotentResponse struct {
+	// Fingerprint identifies the request the key was first used with.
+	Fingerprint string
+	// Done is false while the first request is still being served.
+	Done        bool
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// IdempotencyStore keeps the responses of requests sent with an idempotency
+// key. MemoryIdempotencyStore keeps them in process, a shared backend only
+// has to implement this interface.
+type IdempotencyStore interface {
+	// Reserve claims key for a new request with the given fingerprint. If the
+	// key is already known, the record stored for it is returned instead.
+	Reserve(ctx context.Context, key string, fingerprint string) (*IdempotentResponse, bool, error)
+	// Save records the response of the request that reserved key.
+	Save(ctx context.Context, key string, resp IdempotentResponse) error
+	// Release forgets key, so that the request may be retried.
+	Release(ctx context.Context, key string) error
+}
+
+type idempotencyEntry struct {
+	resp    IdempotentResponse
+	expires time.Time
+}
+
+// MemoryIdempotencyStore keeps every key for ttl after it was reserved.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		ttl:     ttl,
+		entries: make(map[string]*idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryIdempotencyStore) Reserve(_ context.Context, key string, fingerprint string) (*IdempotentResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		resp := e.resp
+		return &resp, false, nil
+	}
+	s.entries[key] = &idempotencyEntry{
+		resp:    IdempotentResponse{Fingerprint: fingerprint},
+		expires: now.Add(s.ttl),
+	}
+	return nil, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Save(_ context.Context, key string, resp IdempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		e.resp = resp
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep drops the expired keys. The caller holds the lock.
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.ttl {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package custommiddleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/FeelDat/urlshort/internal/utils"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds the keys clients may send.
+const maxIdempotencyKeyLength = 255
+
+type IdempotencyMiddleware struct {
+	store IdempotencyStore
+	// maxBodySize bounds the request bodies buffered for fingerprinting.
+	maxBodySize int64
+	logger      *zap.SugaredLogger
+}
+
+// NewIdempotencyMiddleware replays the stored response when a request is
+// retried with the same Idempotency-Key header. Keys are scoped per user, so
+// the middleware has to run after AuthMiddleware. Larger bodies than
+// maxBodySize are refused with 413.
+func NewIdempotencyMiddleware(store IdempotencyStore, maxBodySize int64, logger *zap.SugaredLogger) *IdempotencyMiddleware {
+	return &IdempotencyMiddleware{
+		store:       store,
+		maxBodySize: maxBodySize,
+		logger:      logger,
+	}
+}
+
+func (m *IdempotencyMiddleware) IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+		if idempotencyKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		cookie, err := r.Cookie("jwt")
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		userID, err := utils.GetUserIDFromToken(cookie.Value, utils.JWTSigningKey)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, m.maxBodySize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Request body is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := userID + ":" + idempotencyKey
+		fingerprint := requestFingerprint(r, body)
+
+		stored, reserved, err := m.store.Reserve(r.Context(), key, fingerprint)
+		if err != nil {
+			m.logger.Errorw("Failed to reserve idempotency key", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !reserved {
+			switch {
+			case stored.Fingerprint != fingerprint:
+				http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+			case !stored.Done:
+				http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+			default:
+				replay(w, stored)
+			}
+			return
+		}
+
+		rec := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// server errors are not recorded, the client may retry them
+		if rec.status >= http.StatusInternalServerError {
+			err = m.store.Release(r.Context(), key)
+		} else {
+			err = m.store.Save(r.Context(), key, IdempotentResponse{
+				Fingerprint: fingerprint,
+				Done:        true,
+				Status:      rec.status,
+				ContentType: w.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			})
+		}
+		if err != nil {
+			m.logger.Errorw("Failed to store idempotent response", "error", err)
+		}
+	})
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, resp *IdempotentResponse) {
+	if resp.ContentType != "" {
+		w.Header().Set("Content-Type", resp.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// recordingResponseWriter passes the response through while keeping a copy.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recordingResponseWriter) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.status = statusCode
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *recordingResponseWriter) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
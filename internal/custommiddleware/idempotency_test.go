@@ -0,0 +1,71 @@
+package custommiddleware
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	token, err := NewAuthMiddleware().createToken()
+	require.NoError(t, err)
+
+	calls := 0
+	m := NewIdempotencyMiddleware(NewMemoryIdempotencyStore(time.Hour), 64, zap.NewNop().Sugar())
+	h := m.IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s #%d", body, calls)
+	}))
+
+	doTarget := func(target, key, body string) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.AddCookie(&http.Cookie{Name: "jwt", Value: token})
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Result()
+	}
+	do := func(key, body string) *http.Response {
+		return doTarget("/", key, body)
+	}
+
+	res := do("k1", "https://ya.ru/")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+
+	res = do("k1", "https://ya.ru/")
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "https://ya.ru/ #1", string(body))
+	assert.Equal(t, "true", res.Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, "text/plain", res.Header.Get("Content-Type"))
+
+	res = do("k1", "https://go.dev/")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+
+	res = doTarget("/?ttl=1h", "k1", "https://ya.ru/")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+
+	res = do("k2", strings.Repeat("a", 65))
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+
+	res = do("", "https://ya.ru/")
+	defer res.Body.Close()
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,97 @@
+package custommiddleware
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter decides whether one more request identified by key may pass.
+// MemoryLimiter keeps its state in process, a shared backend (e.g. Redis)
+// only has to implement this interface.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Decision, error)
+}
+
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, zero when
+	// the request was allowed.
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryLimiter is a token bucket per key: each bucket holds up to limit
+// tokens and refills at limit tokens per period.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	rate      float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryLimiter(limit int, period time.Duration) *MemoryLimiter {
+	return &MemoryLimiter{
+		limit:   limit,
+		rate:    float64(limit) / period.Seconds(),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (l *MemoryLimiter) Allow(_ context.Context, key string) (Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.limit), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	d := Decision{Limit: l.limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = l.duration(1 - b.tokens)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = l.duration(float64(l.limit) - b.tokens)
+	return d, nil
+}
+
+func (l *MemoryLimiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// sweep drops the buckets that have refilled completely, they are
+// indistinguishable from new ones. The caller holds the lock.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	full := l.duration(float64(l.limit))
+	if now.Sub(l.lastSweep) < full {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package custommiddleware
+
+import (
+	"github.com/FeelDat/urlshort/internal/utils"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type RateLimitMiddleware struct {
+	limiter Limiter
+	apiKeys map[string]struct{}
+	ips     *utils.ClientIPResolver
+	logger  *zap.SugaredLogger
+}
+
+// NewRateLimitMiddleware limits requests per API key, user or client IP. A nil
+// limiter disables the limit.
+func NewRateLimitMiddleware(limiter Limiter, apiKeys []string, ips *utils.ClientIPResolver, logger *zap.SugaredLogger) *RateLimitMiddleware {
+	keys := make(map[string]struct{}, len(apiKeys))
+	for _, k := range apiKeys {
+		if k != "" {
+			keys[k] = struct{}{}
+		}
+	}
+	return &RateLimitMiddleware{
+		limiter: limiter,
+		apiKeys: keys,
+		ips:     ips,
+		logger:  logger,
+	}
+}
+
+func (m *RateLimitMiddleware) RateLimitMiddleware(next http.Handler) http.Handler {
+	return m.limit(next, m.clientKey)
+}
+
+// LinkRateLimitMiddleware limits requests per client IP and short link, so
+// guessing the password of one link neither depends on the session nor
+// locks the client out of other links. It has to be mounted on a route with
+// an {id} parameter.
+func (m *RateLimitMiddleware) LinkRateLimitMiddleware(next http.Handler) http.Handler {
+	return m.limit(next, func(r *http.Request) string {
+		return "ip:" + m.clientIP(r) + "|link:" + chi.URLParam(r, "id")
+	})
+}
+
+func (m *RateLimitMiddleware) limit(next http.Handler, key func(r *http.Request) string) http.Handler {
+	if m.limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		d, err := m.limiter.Allow(r.Context(), key(r))
+		if err != nil {
+			// a broken limiter backend must not take the service down
+			m.logger.Errorw("Rate limiter failed", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		w.Header().Set("RateLimit-Reset", seconds(d.Reset))
+		if !d.Allowed {
+			w.Header().Set("Retry-After", seconds(d.RetryAfter))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientKey identifies the client by a known API key, by the user of the jwt
+// cookie it sent or by its IP address. Requests the auth middleware has just
+// issued a token for count against the IP, so dropping the cookie does not
+// buy a fresh bucket.
+func (m *RateLimitMiddleware) clientKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		if _, ok := m.apiKeys[key]; ok {
+			return "key:" + key
+		}
+	}
+	if r.Context().Value(newSessionKey{}) == nil {
+		if cookie, err := r.Cookie("jwt"); err == nil {
+			if userID, err := utils.GetUserIDFromToken(cookie.Value, utils.JWTSigningKey); err == nil {
+				return "user:" + userID
+			}
+		}
+	}
+	return "ip:" + m.clientIP(r)
+}
+
+func (m *RateLimitMiddleware) clientIP(r *http.Request) string {
+	if ip := m.ips.ClientIP(r); ip.IsValid() {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,140 @@
+package custommiddleware
+
+import (
+	"context"
+	"github.com/FeelDat/urlshort/internal/utils"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewMemoryLimiter(2, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		d, err := l.Allow(context.Background(), "a")
+		require.NoError(t, err)
+		assert.True(t, d.Allowed)
+	}
+	d, _ := l.Allow(context.Background(), "a")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 30*time.Second, d.RetryAfter)
+
+	d, _ = l.Allow(context.Background(), "b")
+	assert.True(t, d.Allowed, "keys have separate buckets")
+
+	now = now.Add(30 * time.Second)
+	d, _ = l.Allow(context.Background(), "a")
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	m := NewRateLimitMiddleware(NewMemoryLimiter(1, time.Minute), []string{"secret"}, nil, zap.NewNop().Sugar())
+	h := m.RateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(apiKey string) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	res := do("")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "1", res.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "0", res.Header.Get("RateLimit-Remaining"))
+
+	res = do("")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "60", res.Header.Get("Retry-After"))
+
+	// unknown API keys fall back to the client IP
+	res = do("guess")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+
+	res = do("secret")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// users sending their cookie back are limited on their own
+	userToken := func(userID string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"userID": userID}).SignedString([]byte(utils.JWTSigningKey))
+		require.NoError(t, err)
+		return token
+	}
+	withToken := func(token string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.AddCookie(&http.Cookie{Name: "jwt", Value: token})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, withToken(userToken("alice")))
+	assert.Equal(t, http.StatusTooManyRequests, withToken(userToken("alice")))
+	assert.Equal(t, http.StatusOK, withToken(userToken("bob")))
+	assert.Equal(t, http.StatusTooManyRequests, withToken("forged"))
+}
+
+func TestRateLimitMiddlewareNewSession(t *testing.T) {
+	m := NewRateLimitMiddleware(NewMemoryLimiter(1, time.Minute), nil, nil, zap.NewNop().Sugar())
+	h := NewAuthMiddleware().AuthMiddleware(m.RateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	do := func() *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	res := do()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	require.NotEmpty(t, res.Cookies())
+
+	// a freshly issued token does not buy a fresh bucket
+	res = do()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+}
+
+func TestLinkRateLimitMiddleware(t *testing.T) {
+	m := NewRateLimitMiddleware(NewMemoryLimiter(1, time.Minute), []string{"secret"}, nil, zap.NewNop().Sugar())
+	r := chi.NewRouter()
+	r.With(m.LinkRateLimitMiddleware).Post("/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	do := func(id, addr, apiKey string) int {
+		req := httptest.NewRequest(http.MethodPost, "/"+id, nil)
+		req.RemoteAddr = addr
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, do("abc", "10.0.0.1:1234", ""))
+	assert.Equal(t, http.StatusTooManyRequests, do("abc", "10.0.0.1:1234", ""))
+	// API keys do not lift the limit on password guesses
+	assert.Equal(t, http.StatusTooManyRequests, do("abc", "10.0.0.1:1234", "secret"))
+	assert.Equal(t, http.StatusOK, do("xyz", "10.0.0.1:1234", ""))
+	assert.Equal(t, http.StatusOK, do("abc", "10.0.0.2:1234", ""))
+}
@@ -0,0 +1,51 @@
+// Package geoip resolves client IP addresses to countries using a local
+// MaxMind-format (GeoLite2/GeoIP2 Country or City) database.
+package geoip
+
+import (
+	"github.com/oschwald/maxminddb-golang"
+	"net/netip"
+)
+
+type DB struct {
+	reader *maxminddb.Reader
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// Open memory-maps the database at path. An empty path returns a nil DB,
+// which resolves every address to an unknown country.
+func Open(path string) (*DB, error) {
+	if path == "" {
+		return nil, nil
+	}
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{reader: reader}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country of ip, or an
+// empty string if it is unknown.
+func (db *DB) Country(ip netip.Addr) string {
+	if db == nil || !ip.IsValid() {
+		return ""
+	}
+	var record countryRecord
+	if err := db.reader.Lookup(ip.Unmap().AsSlice(), &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+func (db *DB) Close() error {
+	if db == nil {
+		return nil
+	}
+	return db.reader.Close()
+}
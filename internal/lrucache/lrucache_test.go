@@ -0,0 +1,42 @@
+package lrucache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := New[string, int](2)
+
+	c.Set("a", 1, 0)
+	c.Set("b", 2, 0)
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	c.Set("c", 3, 0)
+
+	_, ok = c.Get("b")
+	assert.False(t, ok, "b should have been evicted")
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestCacheExpiresEntries(t *testing.T) {
+	now := time.Now()
+	c := New[string, int](10)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, 0)
+
+	now = now.Add(2 * time.Minute)
+
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	_, ok = c.Get("b")
+	assert.True(t, ok)
+	assert.Equal(t, 1, c.Len())
+}
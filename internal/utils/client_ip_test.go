@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:5000", expected: "203.0.113.7"},
+		{name: "untrusted proxy", remoteAddr: "203.0.113.7:5000", forwarded: "198.51.100.1", expected: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:5000", forwarded: "198.51.100.1", expected: "198.51.100.1"},
+		{name: "proxy chain", remoteAddr: "10.1.2.3:5000", forwarded: "198.51.100.1, 192.168.1.1", expected: "198.51.100.1"},
+		{name: "spoofed header", remoteAddr: "10.1.2.3:5000", forwarded: "1.2.3.4, 198.51.100.1", expected: "198.51.100.1"},
+		{name: "ipv6", remoteAddr: "[2001:db8::1]:5000", expected: "2001:db8::1"},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			assert.Equal(t, tt.expected, resolver.ClientIP(r).String())
+		})
+	}
+
+	_, err = NewClientIPResolver([]string{"not an ip"})
+	assert.Error(t, err)
+}
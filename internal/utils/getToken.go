@@ -6,6 +6,9 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// JWTSigningKey signs and verifies the jwt cookies of the users.
+const JWTSigningKey = "8PNHgjK2kPunGpzMgL0ZmMdJCRKy2EnL/Cg0GbnELLI="
+
 func GetUserIDFromToken(t string, key string) (string, error) {
 	token, err := jwt.Parse(t, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
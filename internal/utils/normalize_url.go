@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/idna"
+	"net"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrURLEmpty     = errors.New("url is empty")
+	ErrURLTooLong   = errors.New("url is too long")
+	ErrURLMalformed = errors.New("url is malformed")
+	ErrURLRelative  = errors.New("url must be absolute")
+	ErrURLScheme    = errors.New("url scheme is not allowed")
+	ErrURLHost      = errors.New("url host is invalid")
+)
+
+// hostProfile is idna.Lookup without the STD3 restriction, so that hosts
+// with underscores, common in the wild, are accepted.
+var hostProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// URLNormalizer validates URLs before they are shortened and brings them to
+// a canonical form, so that equal destinations are stored only once.
+type URLNormalizer struct {
+	schemes   map[string]struct{}
+	maxLength int
+}
+
+// NewURLNormalizer accepts URLs with one of the given schemes, http and https
+// if none are given, and at most maxLength bytes long. A non-positive
+// maxLength disables the length check.
+func NewURLNormalizer(schemes []string, maxLength int) *URLNormalizer {
+	n := &URLNormalizer{
+		schemes:   make(map[string]struct{}, len(schemes)),
+		maxLength: maxLength,
+	}
+	for _, scheme := range schemes {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			n.schemes[scheme] = struct{}{}
+		}
+	}
+	if len(n.schemes) == 0 {
+		n.schemes["http"] = struct{}{}
+		n.schemes["https"] = struct{}{}
+	}
+	return n
+}
+
+// Normalize trims surrounding whitespace, lowercases the scheme and host,
+// converts international domain names to punycode and drops the default port
+// of the scheme. The returned error wraps one of the ErrURL* errors.
+func (n *URLNormalizer) Normalize(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", ErrURLEmpty
+	}
+	if n.maxLength > 0 && len(rawURL) > n.maxLength {
+		return "", fmt.Errorf("%w: more than %d characters", ErrURLTooLong, n.maxLength)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", ErrURLMalformed
+	}
+	if !u.IsAbs() {
+		return "", ErrURLRelative
+	}
+	if _, ok := n.schemes[u.Scheme]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrURLScheme, u.Scheme)
+	}
+	if u.Opaque != "" || u.Host == "" {
+		return "", ErrURLRelative
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if net.ParseIP(host) == nil {
+		host, err = hostProfile.ToASCII(host)
+		if err != nil || host == "" {
+			return "", ErrURLHost
+		}
+	}
+
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	normalized := u.String()
+	if n.maxLength > 0 && len(normalized) > n.maxLength {
+		return "", fmt.Errorf("%w: more than %d characters", ErrURLTooLong, n.maxLength)
+	}
+
+	return normalized, nil
+}
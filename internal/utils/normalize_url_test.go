@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestURLNormalizer_Normalize(t *testing.T) {
+	n := NewURLNormalizer([]string{"http", "https"}, 64)
+
+	testCases := []struct {
+		name     string
+		rawURL   string
+		expected string
+		err      error
+	}{
+		{name: "unchanged", rawURL: "https://practicum.yandex.ru/", expected: "https://practicum.yandex.ru/"},
+		{name: "no path", rawURL: "http://ya.ru", expected: "http://ya.ru"},
+		{name: "trimmed", rawURL: "  https://ya.ru/path?q=1 \n", expected: "https://ya.ru/path?q=1"},
+		{name: "lowercase host", rawURL: "HTTPS://Practicum.Yandex.RU/Path", expected: "https://practicum.yandex.ru/Path"},
+		{name: "default port", rawURL: "https://ya.ru:443/", expected: "https://ya.ru/"},
+		{name: "custom port", rawURL: "http://ya.ru:8080/", expected: "http://ya.ru:8080/"},
+		{name: "idn", rawURL: "https://пример.рф/", expected: "https://xn--e1afmkfd.xn--p1ai/"},
+		{name: "ipv6", rawURL: "http://[::1]:80/", expected: "http://[::1]/"},
+		{name: "underscore", rawURL: "https://my_host.example.com/", expected: "https://my_host.example.com/"},
+		{name: "empty", rawURL: "   ", err: ErrURLEmpty},
+		{name: "too long", rawURL: "https://ya.ru/" + string(make([]byte, 64)), err: ErrURLTooLong},
+		{name: "javascript", rawURL: "javascript:alert(1)", err: ErrURLScheme},
+		{name: "relative", rawURL: "/some/path", err: ErrURLRelative},
+		{name: "no host", rawURL: "https:///path", err: ErrURLRelative},
+		{name: "malformed", rawURL: "http://[::1", err: ErrURLMalformed},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := n.Normalize(tt.rawURL)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/skip2/go-qrcode"
+)
+
+// Formats of EncodeQR.
+const (
+	QRFormatPNG = "png"
+	QRFormatSVG = "svg"
+)
+
+// QRLevels maps the letters of the QR error-correction levels to the levels
+// of the encoder.
+var QRLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// EncodeQR renders content as a QR code image of size pixels square,
+// including the quiet zone, in format.
+func EncodeQR(content string, level qrcode.RecoveryLevel, size int, format string) ([]byte, error) {
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case QRFormatPNG:
+		return code.PNG(size)
+	case QRFormatSVG:
+		return qrSVG(code.Bitmap(), size), nil
+	}
+	return nil, fmt.Errorf("unknown QR code format %q", format)
+}
+
+// qrSVG draws every run of dark modules of a row as a single rectangle,
+// which keeps the document small.
+func qrSVG(bitmap [][]bool, size int) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, len(bitmap), len(bitmap))
+	buf.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range bitmap {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"bytes"
+	"github.com/skip2/go-qrcode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image/png"
+	"testing"
+)
+
+func TestEncodeQR(t *testing.T) {
+	data, err := EncodeQR("http://localhost:8080/abc", qrcode.Medium, 256, QRFormatPNG)
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 256, img.Bounds().Dx())
+
+	data, err = EncodeQR("http://localhost:8080/abc", qrcode.Highest, 128, QRFormatSVG)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte("<svg ")))
+	assert.Contains(t, string(data), `width="128"`)
+
+	_, err = EncodeQR("http://localhost:8080/abc", qrcode.Medium, 256, "gif")
+	assert.Error(t, err)
+}
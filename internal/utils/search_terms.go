@@ -0,0 +1,14 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SearchTerms splits s into lower case words of letters and digits, the way
+// both link storages index and query links.
+func SearchTerms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
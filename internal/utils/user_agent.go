@@ -0,0 +1,67 @@
+package utils
+
+import "strings"
+
+// Operating systems and device classes reported by ParseUserAgent.
+const (
+	OSiOS      = "ios"
+	OSAndroid  = "android"
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSLinux    = "linux"
+	OSChromeOS = "chromeos"
+	OSOther    = "other"
+
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+)
+
+type UserAgent struct {
+	OS     string
+	Device string
+	Bot    bool
+}
+
+var botMarkers = []string{
+	"bot", "crawler", "spider", "slurp", "preview", "facebookexternalhit",
+	"curl", "wget", "python-requests", "go-http-client", "headless",
+}
+
+// ParseUserAgent classifies a User-Agent header. It only looks for the
+// well-known platform tokens, which is enough to pick a redirect target.
+func ParseUserAgent(header string) UserAgent {
+	ua := strings.ToLower(header)
+	res := UserAgent{OS: OSOther, Device: DeviceDesktop}
+
+	switch {
+	case strings.Contains(ua, "ipad"):
+		res.OS, res.Device = OSiOS, DeviceTablet
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipod"):
+		res.OS, res.Device = OSiOS, DeviceMobile
+	case strings.Contains(ua, "android"):
+		res.OS, res.Device = OSAndroid, DeviceTablet
+		if strings.Contains(ua, "mobile") {
+			res.Device = DeviceMobile
+		}
+	case strings.Contains(ua, "windows"):
+		res.OS = OSWindows
+	case strings.Contains(ua, "cros"):
+		res.OS = OSChromeOS
+	case strings.Contains(ua, "macintosh"), strings.Contains(ua, "mac os x"):
+		res.OS = OSMacOS
+	case strings.Contains(ua, "linux"):
+		res.OS = OSLinux
+	}
+
+	if ua == "" {
+		res.Bot = true
+	}
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			res.Bot = true
+			break
+		}
+	}
+	return res
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want UserAgent
+	}{
+		{
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+			want: UserAgent{OS: OSiOS, Device: DeviceMobile},
+		},
+		{
+			ua:   "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			want: UserAgent{OS: OSiOS, Device: DeviceTablet},
+		},
+		{
+			ua:   "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36",
+			want: UserAgent{OS: OSAndroid, Device: DeviceMobile},
+		},
+		{
+			ua:   "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36",
+			want: UserAgent{OS: OSAndroid, Device: DeviceTablet},
+		},
+		{
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36",
+			want: UserAgent{OS: OSWindows, Device: DeviceDesktop},
+		},
+		{
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15",
+			want: UserAgent{OS: OSMacOS, Device: DeviceDesktop},
+		},
+		{
+			ua:   "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want: UserAgent{OS: OSOther, Device: DeviceDesktop, Bot: true},
+		},
+		{
+			ua:   "",
+			want: UserAgent{OS: OSOther, Device: DeviceDesktop, Bot: true},
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ParseUserAgent(tt.ua), tt.ua)
+	}
+}